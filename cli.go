@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"garm-provider-harvester/pkg/config"
+	"garm-provider-harvester/pkg/provider"
+)
+
+// Operator commands are run by hand rather than by GARM:
+//
+//	garm-provider-harvester [-config FILE] [-controller-id ID] COMMAND INSTANCE
+//	garm-provider-harvester [-config FILE] [-controller-id ID] stop [-force] INSTANCE
+//	garm-provider-harvester [-config FILE] [-controller-id ID] gc [-dry-run] [-max-age AGE]
+//
+// The config file and controller ID default to the GARM_PROVIDER_CONFIG_FILE and
+// GARM_CONTROLLER_ID environment variables GARM sets for the provider.
+var (
+	configFile   = flag.String("config", os.Getenv("GARM_PROVIDER_CONFIG_FILE"), "provider config file used by operator commands")
+	controllerID = flag.String("controller-id", os.Getenv("GARM_CONTROLLER_ID"), "GARM controller ID used by operator commands")
+)
+
+var instanceCommands = map[string]func(*provider.HarvesterProvider, context.Context, string) error{
+	"restart": (*provider.HarvesterProvider).Restart,
+	"pause":   (*provider.HarvesterProvider).Pause,
+	"unpause": (*provider.HarvesterProvider).Unpause,
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [restart|pause|unpause INSTANCE | stop [-force] INSTANCE | gc [-dry-run] [-max-age AGE]]\n\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "Without a command the provider runs the GARM command set in GARM_COMMAND.\n\n")
+	flag.PrintDefaults()
+}
+
+func newOperatorProvider() (*provider.HarvesterProvider, error) {
+	if *configFile == "" {
+		return nil, fmt.Errorf("missing -config or GARM_PROVIDER_CONFIG_FILE")
+	}
+	if *controllerID == "" {
+		return nil, fmt.Errorf("missing -controller-id or GARM_CONTROLLER_ID")
+	}
+	provConfig, err := config.NewProviderConfig(*configFile)
+	if err != nil {
+		return nil, err
+	}
+	prov, err := provider.NewHarvesterProvider(provConfig, *controllerID)
+	if err != nil {
+		return nil, err
+	}
+	return prov.(*provider.HarvesterProvider), nil
+}
+
+func runOperatorCommand(ctx context.Context, args []string) error {
+	switch args[0] {
+	case "gc":
+		return runGC(ctx, args[1:])
+	case "stop":
+		return runStop(ctx, args[1:])
+	}
+
+	command, ok := instanceCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("%s takes exactly one instance", args[0])
+	}
+
+	prov, err := newOperatorProvider()
+	if err != nil {
+		return err
+	}
+	return command(prov, ctx, args[1])
+}
+
+// runStop stops a runner. Unlike the stops GARM asks for, it is graceful unless
+// -force is given.
+func runStop(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("stop", flag.ContinueOnError)
+	force := flags.Bool("force", false, "power the runner off without waiting for its guest to shut down")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("stop takes exactly one instance")
+	}
+
+	prov, err := newOperatorProvider()
+	if err != nil {
+		return err
+	}
+	return prov.Shutdown(ctx, flags.Arg(0), *force)
+}
+
+// runGC collects the resources failed creates and deletes left behind and
+// prints a JSON report of them to stdout.
+func runGC(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report what would be removed without removing it")
+	maxAge := flags.Duration("max-age", provider.DefaultGCMaxAge, "how old a leftover resource has to be to be removed")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("gc takes no arguments")
+	}
+
+	prov, err := newOperatorProvider()
+	if err != nil {
+		return err
+	}
+	report, gcErr := prov.CollectGarbage(ctx, provider.GCOptions{DryRun: *dryRun, MaxAge: *maxAge})
+	if report != nil {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "    ")
+		if err := encoder.Encode(report); err != nil {
+			return fmt.Errorf("failed to print report: %w", err)
+		}
+	}
+	return gcErr
+}
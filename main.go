@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"log/slog"
 	"os"
 	"os/signal"
+	"reflect"
 	"syscall"
 
 	"garm-provider-harvester/pkg/config"
@@ -28,22 +30,56 @@ var signals = []os.Signal{
 	syscall.SIGTERM,
 }
 
+var (
+	printConfigSchema     = flag.Bool("print-config-schema", false, "print the JSON schema of the provider config and exit")
+	printExtraSpecsSchema = flag.Bool("print-extra-specs-schema", false, "print the JSON schema of the pool extra specs and exit")
+)
+
+func printSchema(schemaFunc func() (string, error)) {
+	schema, err := schemaFunc()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintln(os.Stdout, schema)
+}
+
 func main() {
+	flag.Usage = usage
+	flag.Parse()
 	setupLogging()
+
+	switch {
+	case *printConfigSchema:
+		printSchema(config.ConfigJSONSchema)
+		return
+	case *printExtraSpecsSchema:
+		printSchema(config.ExtraSpecsJSONSchema)
+		return
+	}
+
 	ctx, stop := signal.NotifyContext(context.Background(), signals...)
 	defer stop()
 
+	if flag.NArg() > 0 {
+		if err := runOperatorCommand(ctx, flag.Args()); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to run %s: %s\n", flag.Arg(0), err)
+			os.Exit(commonExecution.ResolveErrorToExitCode(err))
+		}
+		return
+	}
+
 	executionEnv, err := execution.GetEnvironment()
 	if err != nil {
 		log.Fatal(err)
 	}
 	slog.Debug(fmt.Sprintf("executionEnv.ProviderConfigFile: %s", executionEnv.ProviderConfigFile))
+	slog.Debug(fmt.Sprintf("executionEnv.InterfaceVersion: %s", executionEnv.InterfaceVersion))
 
 	provConfig, err := config.NewProviderConfig(executionEnv.ProviderConfigFile)
 	if err != nil {
 		log.Fatal(err)
 	}
-	if (provConfig == config.Config{}) {
+	if reflect.ValueOf(provConfig).IsZero() {
 		log.Fatalf("%s created an empty config", executionEnv.ProviderConfigFile)
 	}
 
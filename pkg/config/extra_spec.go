@@ -2,41 +2,322 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"path"
+	"reflect"
+	"slices"
 
-	"github.com/harvester/harvester/pkg/builder"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+const extraSpecsSchemaID = "http://cloudbase.it/garm-provider-harvester/schemas/extra_specs#"
+
 type HarvesterExtraSpec struct {
-	NetworkName string `json:"network_name,omitempty"`
-	NetworkAdapterType string `json:"network_adapter_type,omitempty"`
-	NetworkType string `json:"network_type,omitempty"`
-	DiskConnectorType string `json:"disk_connector_type,omitempty"`
+	NetworkName           string           `json:"network_name,omitempty" description:"The NetworkAttachmentDefinition runners will be connected to, as namespace/name. Defaults to the pod network."`
+	NetworkAdapterType    string           `json:"network_adapter_type,omitempty" enum:"virtio,e1000,e1000e,pcnet,ne2k_pci,rtl8139" description:"The model of the runner network interface. Default is virtio."`
+	NetworkType           string           `json:"network_type,omitempty" enum:"bridge,masquerade" description:"How the runner network interface is bound to the network. Default is masquerade."`
+	DiskConnectorType     string           `json:"disk_connector_type,omitempty" enum:"virtio,sata,scsi" description:"The bus the root disk is attached to. Default is virtio."`
+	BootDiskSize          string           `json:"boot_disk_size,omitempty" description:"Size of the root disk, such as 40Gi. Overrides the disk of the flavor and must hold the virtual size of the image."`
+	Disks                 []DiskSpec       `json:"disks,omitempty" description:"Extra data or scratch disks attached to every runner."`
+	Networks              []NetworkSpec    `json:"networks,omitempty" description:"Network interfaces of the runner, in order. Replaces network_name, network_adapter_type and network_type."`
+	GPUs                  []DeviceSpec     `json:"gpus,omitempty" description:"vGPU profiles or GPUs passed through to every runner."`
+	HostDevices           []DeviceSpec     `json:"host_devices,omitempty" description:"PCI devices passed through to every runner."`
+	Firmware              string           `json:"firmware,omitempty" enum:"bios,efi,secure_boot" description:"Firmware of the runner: bios, efi, or efi with secure_boot. Default is secure_boot for Windows runners and bios otherwise."`
+	TPM                   *bool            `json:"tpm,omitempty" description:"Attach an emulated TPM 2.0 to the runner. Default is true for Windows runners."`
+	HyperV                *bool            `json:"hyperv,omitempty" description:"Enable the Hyper-V enlightenments and clock timers Windows guests need. Default is true for Windows runners."`
+	CPUSockets            int              `json:"cpu_sockets,omitempty" description:"Number of CPU sockets. Default is 1."`
+	CPUCores              int              `json:"cpu_cores,omitempty" description:"Number of cores per socket. Default is the vCPUs of the flavor divided by sockets and threads."`
+	CPUThreads            int              `json:"cpu_threads,omitempty" description:"Number of threads per core. Default is 1."`
+	CPUModel              string           `json:"cpu_model,omitempty" description:"CPU model of the runner: host-passthrough, host-model or a named model such as Cascadelake-Server. Default is the cluster default."`
+	CPUFeatures           []CPUFeatureSpec `json:"cpu_features,omitempty" description:"CPU features to require or disable, such as vmx for nested virtualization."`
+	DedicatedCPUPlacement bool             `json:"dedicated_cpu_placement,omitempty" description:"Pin the vCPUs of the runner to dedicated CPUs of the node. Needs the CPU manager on the node."`
+	Hugepages             string           `json:"hugepages,omitempty" enum:"2Mi,1Gi" description:"Back the memory of the runner with hugepages of this size. The node has to have them allocated."`
+	Placement
 }
 
-func (h HarvesterExtraSpec) Validate() error {
-	if h.NetworkType != "" {
-		if h.NetworkType != "bridge" && 
-				h.NetworkType != "masquerade" {
-			return fmt.Errorf("invalid network_type: %s", h.NetworkType)
+const (
+	FirmwareBIOS       = "bios"
+	FirmwareEFI        = "efi"
+	FirmwareSecureBoot = "secure_boot"
+)
+
+// FirmwareSettings returns the firmware, whether to attach a TPM and whether
+// to enable the Hyper-V features of a runner. Windows runners default to what
+// Windows 11 and Server 2025 need: EFI with Secure Boot, a TPM and Hyper-V
+// enlightenments.
+func (h HarvesterExtraSpec) FirmwareSettings(windows bool) (firmware string, tpm, hyperV bool) {
+	firmware, tpm, hyperV = FirmwareBIOS, false, false
+	if windows {
+		firmware, tpm, hyperV = FirmwareSecureBoot, true, true
+	}
+	if h.Firmware != "" {
+		firmware = h.Firmware
+	}
+	if h.TPM != nil {
+		tpm = *h.TPM
+	}
+	if h.HyperV != nil {
+		hyperV = *h.HyperV
+	}
+	return firmware, tpm, hyperV
+}
+
+// CPUFeatureSpec is a CPU feature of a runner.
+type CPUFeatureSpec struct {
+	Name   string `json:"name" required:"true" description:"Name of the feature, such as vmx or svm."`
+	Policy string `json:"policy,omitempty" enum:"force,require,optional,disable,forbid" description:"How the feature is handled. Default is require."`
+}
+
+// CPUTopology returns the sockets, cores and threads the vCPUs of a runner
+// are laid out in. It fails if they do not add up to the vCPUs.
+func (h HarvesterExtraSpec) CPUTopology(vcpus int) (sockets, cores, threads int, err error) {
+	sockets, cores, threads = max(h.CPUSockets, 1), h.CPUCores, max(h.CPUThreads, 1)
+	if cores == 0 {
+		if vcpus%(sockets*threads) != 0 {
+			return 0, 0, 0, fmt.Errorf("%d vCPUs cannot be split into %d sockets with %d threads per core", vcpus, sockets, threads)
 		}
+		cores = vcpus / (sockets * threads)
 	}
-	if h.NetworkAdapterType != "" {
-		if h.NetworkAdapterType != "virtio" && 
-				h.NetworkAdapterType != "e1000" && 
-				h.NetworkAdapterType != "e1000e" && 
-				h.NetworkAdapterType != "pcnet" && 
-				h.NetworkAdapterType != "ne2k_pci" && 
-				h.NetworkAdapterType != "rtl8139" {
-			return fmt.Errorf("invalid network_adapter_type: %s", h.NetworkAdapterType)
+	if sockets*cores*threads != vcpus {
+		return 0, 0, 0, fmt.Errorf("%d sockets with %d cores and %d threads do not add up to %d vCPUs", sockets, cores, threads, vcpus)
+	}
+	return sockets, cores, threads, nil
+}
+
+// HasCPUTopology reports whether the topology of the vCPUs is set.
+func (h HarvesterExtraSpec) HasCPUTopology() bool {
+	return h.CPUSockets != 0 || h.CPUCores != 0 || h.CPUThreads != 0
+}
+
+// DeviceSpec is a host device passed through to a runner.
+type DeviceSpec struct {
+	DeviceName string `json:"device_name" required:"true" description:"Resource name of the device as permitted in the KubeVirt config by Harvester, such as nvidia.com/NVIDIA_A2-2Q or intel.com/82599_ETHERNET_CONTROLLER_VIRTUAL_FUNCTION."`
+	Count      int    `json:"count,omitempty" description:"How many of the device every runner gets. Default is 1."`
+}
+
+func (d DeviceSpec) Validate() error {
+	if errs := validation.IsQualifiedName(d.DeviceName); len(errs) > 0 {
+		return fmt.Errorf("invalid device_name %q: %s", d.DeviceName, errs[0])
+	}
+	if d.Count < 0 {
+		return fmt.Errorf("invalid count: %d", d.Count)
+	}
+	return nil
+}
+
+// DeviceCount returns how many of the device every runner gets.
+func (d DeviceSpec) DeviceCount() int {
+	if d.Count == 0 {
+		return 1
+	}
+	return d.Count
+}
+
+// NetworkSpec is a network interface of a runner.
+type NetworkSpec struct {
+	NetworkName        string `json:"network_name,omitempty" description:"The NetworkAttachmentDefinition the interface is connected to, as namespace/name. Empty for the pod network."`
+	NetworkAdapterType string `json:"network_adapter_type,omitempty" enum:"virtio,e1000,e1000e,pcnet,ne2k_pci,rtl8139" description:"The model of the interface. Default is virtio."`
+	NetworkType        string `json:"network_type,omitempty" enum:"bridge,masquerade" description:"How the interface is bound to the network. Default is masquerade on the pod network and bridge otherwise."`
+	MACAddress         string `json:"mac_address,omitempty" description:"MAC address of the interface. Assigned by KubeVirt if empty. Every runner of the pool gets this address, so it only works for pools with max_runners set to 1."`
+}
+
+func (n NetworkSpec) Validate() error {
+	if n.MACAddress != "" {
+		if _, err := net.ParseMAC(n.MACAddress); err != nil {
+			return fmt.Errorf("invalid mac_address: %s", n.MACAddress)
+		}
+	}
+	if n.NetworkName != "" && n.NetworkType == "masquerade" {
+		return fmt.Errorf("network_type masquerade is only supported on the pod network")
+	}
+	return nil
+}
+
+// NetworkInterfaces returns the network interfaces of a runner with their
+// defaults applied. Pools without networks get the single interface described
+// by network_name, network_adapter_type and network_type.
+func (h HarvesterExtraSpec) NetworkInterfaces() []NetworkSpec {
+	if len(h.Networks) == 0 {
+		nic := NetworkSpec{
+			NetworkName:        h.NetworkName,
+			NetworkAdapterType: h.NetworkAdapterType,
+			NetworkType:        h.NetworkType,
+		}
+		if nic.NetworkAdapterType == "" {
+			nic.NetworkAdapterType = "virtio"
+		}
+		if nic.NetworkType == "" {
+			nic.NetworkType = "masquerade"
 		}
+		return []NetworkSpec{nic}
 	}
-	if h.DiskConnectorType != "" {
-		if h.DiskConnectorType != builder.DiskBusVirtio &&
-				h.DiskConnectorType != builder.DiskBusSata &&
-				h.DiskConnectorType != builder.DiskBusScsi {
-			return fmt.Errorf("invalid disk_connector_type: %s", h.DiskConnectorType)
+
+	nics := make([]NetworkSpec, 0, len(h.Networks))
+	for _, nic := range h.Networks {
+		if nic.NetworkAdapterType == "" {
+			nic.NetworkAdapterType = "virtio"
+		}
+		if nic.NetworkType == "" {
+			nic.NetworkType = "bridge"
+			if nic.NetworkName == "" {
+				nic.NetworkType = "masquerade"
+			}
 		}
+		nics = append(nics, nic)
+	}
+	return nics
+}
+
+const (
+	DiskTypePVC       = "pvc"
+	DiskTypeEmptyDisk = "empty_disk"
+	DiskTypeEphemeral = "ephemeral"
+
+	// maxDiskNameLength is the longest serial QEMU accepts for a virtio disk.
+	// The disk name is used as serial so the guest finds it under /dev/disk/by-id.
+	maxDiskNameLength = 20
+)
+
+// reservedDiskNames are the disks CreateInstance always attaches.
+var reservedDiskNames = []string{"rootdisk", "cloudinitdisk"}
+
+// DiskSpec is an extra disk attached to every runner of a pool.
+type DiskSpec struct {
+	Name         string `json:"name" required:"true" description:"Name of the disk. It is also the disk serial, so Linux runners find it as /dev/disk/by-id/virtio-<name> on the virtio bus."`
+	Type         string `json:"type,omitempty" enum:"pvc,empty_disk,ephemeral" description:"pvc creates a volume for every runner, empty_disk a sparse disk on the node that lives as long as the runner is running, ephemeral a copy-on-write overlay of the existing PVC claim_name. Default is pvc."`
+	Size         string `json:"size,omitempty" description:"Size of the disk, such as 100Gi. Required for pvc and empty_disk."`
+	Bus          string `json:"bus,omitempty" enum:"virtio,sata,scsi" description:"The bus the disk is attached to. Default is virtio."`
+	StorageClass string `json:"storage_class,omitempty" description:"Storage class of a pvc disk. Defaults to the default storage class of the cluster."`
+	ClaimName    string `json:"claim_name,omitempty" description:"The PVC backing an ephemeral disk. Required for ephemeral."`
+	MountPath    string `json:"mount_path,omitempty" description:"Where cloud-init mounts the disk on Linux runners. A disk without a filesystem is formatted first."`
+	Filesystem   string `json:"filesystem,omitempty" enum:"ext4,xfs" description:"Filesystem a disk mounted at mount_path is formatted with. Default is ext4."`
+	AutoDelete   *bool  `json:"auto_delete,omitempty" description:"Whether the volume of a pvc disk is deleted with its runner. Default is true."`
+}
+
+// DiskType returns the type of the disk, pvc unless set.
+func (d DiskSpec) DiskType() string {
+	if d.Type == "" {
+		return DiskTypePVC
 	}
+	return d.Type
+}
+
+// ShouldAutoDelete reports whether the volume of the disk goes away with its runner.
+func (d DiskSpec) ShouldAutoDelete() bool {
+	return d.AutoDelete == nil || *d.AutoDelete
+}
 
+func (d DiskSpec) Validate() error {
+	if errs := validation.IsDNS1123Label(d.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid name %q: %s", d.Name, errs[0])
+	}
+	if len(d.Name) > maxDiskNameLength {
+		return fmt.Errorf("invalid name %q: must be no more than %d characters", d.Name, maxDiskNameLength)
+	}
+	if slices.Contains(reservedDiskNames, d.Name) {
+		return fmt.Errorf("invalid name %q: reserved for the disks of the provider", d.Name)
+	}
+	switch d.DiskType() {
+	case DiskTypePVC, DiskTypeEmptyDisk:
+		if d.Size == "" {
+			return fmt.Errorf("missing size of %s disk %s", d.DiskType(), d.Name)
+		}
+		if d.ClaimName != "" {
+			return fmt.Errorf("claim_name is only valid for ephemeral disks")
+		}
+	case DiskTypeEphemeral:
+		if d.ClaimName == "" {
+			return fmt.Errorf("missing claim_name of ephemeral disk %s", d.Name)
+		}
+		if d.Size != "" {
+			return fmt.Errorf("size is not valid for ephemeral disks")
+		}
+	}
+	if d.Size != "" {
+		if _, err := resource.ParseQuantity(d.Size); err != nil {
+			return fmt.Errorf("invalid size: %s", d.Size)
+		}
+	}
+	if d.StorageClass != "" && d.DiskType() != DiskTypePVC {
+		return fmt.Errorf("storage_class is only valid for pvc disks")
+	}
+	if d.AutoDelete != nil && d.DiskType() != DiskTypePVC {
+		return fmt.Errorf("auto_delete is only valid for pvc disks")
+	}
+	if d.MountPath != "" && !path.IsAbs(d.MountPath) {
+		return fmt.Errorf("invalid mount_path: %s", d.MountPath)
+	}
+	if d.Filesystem != "" && d.MountPath == "" {
+		return fmt.Errorf("filesystem is only valid with mount_path")
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+func (h HarvesterExtraSpec) Validate() error {
+	if err := validateEnums(h, "json"); err != nil {
+		return err
+	}
+	if h.BootDiskSize != "" {
+		if _, err := resource.ParseQuantity(h.BootDiskSize); err != nil {
+			return fmt.Errorf("invalid boot_disk_size: %s", h.BootDiskSize)
+		}
+	}
+	names := map[string]bool{}
+	for i, disk := range h.Disks {
+		if err := disk.Validate(); err != nil {
+			return fmt.Errorf("invalid disks[%d]: %w", i, err)
+		}
+		if names[disk.Name] {
+			return fmt.Errorf("invalid disks[%d]: duplicate name %q", i, disk.Name)
+		}
+		names[disk.Name] = true
+	}
+	if len(h.Networks) > 0 && (h.NetworkName != "" || h.NetworkAdapterType != "" || h.NetworkType != "") {
+		return fmt.Errorf("networks cannot be combined with network_name, network_adapter_type or network_type")
+	}
+	podNetworks := 0
+	for i, nic := range h.Networks {
+		if err := nic.Validate(); err != nil {
+			return fmt.Errorf("invalid networks[%d]: %w", i, err)
+		}
+		if nic.NetworkName == "" {
+			podNetworks++
+		}
+	}
+	if podNetworks > 1 {
+		return fmt.Errorf("invalid networks: only one interface can use the pod network")
+	}
+	if h.CPUSockets < 0 || h.CPUCores < 0 || h.CPUThreads < 0 {
+		return fmt.Errorf("invalid cpu topology: cpu_sockets, cpu_cores and cpu_threads cannot be negative")
+	}
+	features := map[string]bool{}
+	for i, feature := range h.CPUFeatures {
+		if feature.Name == "" {
+			return fmt.Errorf("invalid cpu_features[%d]: missing name", i)
+		}
+		if features[feature.Name] {
+			return fmt.Errorf("invalid cpu_features[%d]: duplicate name %q", i, feature.Name)
+		}
+		features[feature.Name] = true
+	}
+	for i, gpu := range h.GPUs {
+		if err := gpu.Validate(); err != nil {
+			return fmt.Errorf("invalid gpus[%d]: %w", i, err)
+		}
+	}
+	for i, device := range h.HostDevices {
+		if err := device.Validate(); err != nil {
+			return fmt.Errorf("invalid host_devices[%d]: %w", i, err)
+		}
+	}
+	return h.Placement.Validate()
+}
+
+// ExtraSpecsJSONSchema returns the JSON schema of HarvesterExtraSpec.
+func ExtraSpecsJSONSchema() (string, error) {
+	schema := schemaFor(reflect.TypeOf(HarvesterExtraSpec{}), "json")
+	schema.Schema = extraSpecsSchemaID
+	schema.Description = "Schema defining supported extra specs for the Garm Harvester Provider"
+	return marshalSchema(schema)
+}
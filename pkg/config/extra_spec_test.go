@@ -0,0 +1,263 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/harvester/harvester/pkg/builder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtraSpecValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      HarvesterExtraSpec
+		errString string
+	}{
+		{
+			name:      "empty spec",
+			spec:      HarvesterExtraSpec{},
+			errString: "",
+		},
+		{
+			name: "valid spec",
+			spec: HarvesterExtraSpec{
+				NetworkName:        "harvester-public/harvester-public-net",
+				NetworkAdapterType: "e1000",
+				NetworkType:        "bridge",
+				DiskConnectorType:  builder.DiskBusSata,
+			},
+			errString: "",
+		},
+		{
+			name:      "invalid network type",
+			spec:      HarvesterExtraSpec{NetworkType: "nat"},
+			errString: "invalid network_type: nat",
+		},
+		{
+			name:      "invalid adapter type",
+			spec:      HarvesterExtraSpec{NetworkAdapterType: "vmxnet3"},
+			errString: "invalid network_adapter_type: vmxnet3",
+		},
+		{
+			name:      "invalid disk connector",
+			spec:      HarvesterExtraSpec{DiskConnectorType: "ide"},
+			errString: "invalid disk_connector_type: ide",
+		},
+		{
+			name:      "valid boot disk size",
+			spec:      HarvesterExtraSpec{BootDiskSize: "40Gi"},
+			errString: "",
+		},
+		{
+			name:      "invalid boot disk size",
+			spec:      HarvesterExtraSpec{BootDiskSize: "40 GB"},
+			errString: "invalid boot_disk_size: 40 GB",
+		},
+		{
+			name: "valid disks",
+			spec: HarvesterExtraSpec{Disks: []DiskSpec{
+				{Name: "scratch", Type: DiskTypeEmptyDisk, Size: "50Gi", MountPath: "/mnt/scratch", Filesystem: "xfs"},
+				{Name: "data", Size: "100Gi", Bus: builder.DiskBusScsi, StorageClass: "longhorn-ssd", AutoDelete: new(bool)},
+				{Name: "cache", Type: DiskTypeEphemeral, ClaimName: "go-mod-cache", MountPath: "/mnt/cache"},
+			}},
+			errString: "",
+		},
+		{
+			name:      "invalid disk type",
+			spec:      HarvesterExtraSpec{Disks: []DiskSpec{{Name: "scratch", Type: "tmpfs"}}},
+			errString: "invalid disks[0].type: tmpfs",
+		},
+		{
+			name:      "disk without size",
+			spec:      HarvesterExtraSpec{Disks: []DiskSpec{{Name: "scratch"}}},
+			errString: "invalid disks[0]: missing size of pvc disk scratch",
+		},
+		{
+			name:      "ephemeral disk without claim",
+			spec:      HarvesterExtraSpec{Disks: []DiskSpec{{Name: "cache", Type: DiskTypeEphemeral}}},
+			errString: "invalid disks[0]: missing claim_name of ephemeral disk cache",
+		},
+		{
+			name:      "reserved disk name",
+			spec:      HarvesterExtraSpec{Disks: []DiskSpec{{Name: "rootdisk", Size: "1Gi"}}},
+			errString: `invalid disks[0]: invalid name "rootdisk": reserved for the disks of the provider`,
+		},
+		{
+			name:      "relative mount path",
+			spec:      HarvesterExtraSpec{Disks: []DiskSpec{{Name: "scratch", Size: "1Gi", MountPath: "scratch"}}},
+			errString: "invalid disks[0]: invalid mount_path: scratch",
+		},
+		{
+			name: "duplicate disk name",
+			spec: HarvesterExtraSpec{Disks: []DiskSpec{
+				{Name: "scratch", Size: "1Gi"},
+				{Name: "scratch", Type: DiskTypeEmptyDisk, Size: "1Gi"},
+			}},
+			errString: `invalid disks[1]: duplicate name "scratch"`,
+		},
+		{
+			name: "valid networks",
+			spec: HarvesterExtraSpec{Networks: []NetworkSpec{
+				{NetworkAdapterType: "e1000"},
+				{NetworkName: "ci/vlan-100", MACAddress: "02:00:00:00:01:00"},
+			}},
+			errString: "",
+		},
+		{
+			name: "networks with network_name",
+			spec: HarvesterExtraSpec{
+				NetworkName: "ci/vlan-100",
+				Networks:    []NetworkSpec{{NetworkName: "ci/vlan-200"}},
+			},
+			errString: "networks cannot be combined with network_name, network_adapter_type or network_type",
+		},
+		{
+			name:      "invalid network model",
+			spec:      HarvesterExtraSpec{Networks: []NetworkSpec{{NetworkAdapterType: "vmxnet3"}}},
+			errString: "invalid networks[0].network_adapter_type: vmxnet3",
+		},
+		{
+			name:      "invalid mac address",
+			spec:      HarvesterExtraSpec{Networks: []NetworkSpec{{NetworkName: "ci/vlan-100", MACAddress: "02:00:00"}}},
+			errString: "invalid networks[0]: invalid mac_address: 02:00:00",
+		},
+		{
+			name:      "masquerade on multus network",
+			spec:      HarvesterExtraSpec{Networks: []NetworkSpec{{NetworkName: "ci/vlan-100", NetworkType: "masquerade"}}},
+			errString: "invalid networks[0]: network_type masquerade is only supported on the pod network",
+		},
+		{
+			name:      "two pod networks",
+			spec:      HarvesterExtraSpec{Networks: []NetworkSpec{{}, {NetworkType: "bridge"}}},
+			errString: "invalid networks: only one interface can use the pod network",
+		},
+		{
+			name: "valid devices",
+			spec: HarvesterExtraSpec{
+				GPUs:        []DeviceSpec{{DeviceName: "nvidia.com/NVIDIA_A2-2Q", Count: 2}},
+				HostDevices: []DeviceSpec{{DeviceName: "intel.com/82599_ETHERNET_CONTROLLER_VIRTUAL_FUNCTION"}},
+			},
+			errString: "",
+		},
+		{
+			name:      "missing gpu device name",
+			spec:      HarvesterExtraSpec{GPUs: []DeviceSpec{{Count: 1}}},
+			errString: `invalid gpus[0]: invalid device_name "": name part must be non-empty`,
+		},
+		{
+			name:      "negative host device count",
+			spec:      HarvesterExtraSpec{HostDevices: []DeviceSpec{{DeviceName: "intel.com/QAT", Count: -1}}},
+			errString: "invalid host_devices[0]: invalid count: -1",
+		},
+		{
+			name:      "invalid firmware",
+			spec:      HarvesterExtraSpec{Firmware: "uefi"},
+			errString: "invalid firmware: uefi",
+		},
+		{
+			name:      "invalid hugepages",
+			spec:      HarvesterExtraSpec{Hugepages: "4Ki"},
+			errString: "invalid hugepages: 4Ki",
+		},
+		{
+			name:      "invalid cpu feature policy",
+			spec:      HarvesterExtraSpec{CPUFeatures: []CPUFeatureSpec{{Name: "vmx", Policy: "enable"}}},
+			errString: "invalid cpu_features[0].policy: enable",
+		},
+		{
+			name:      "duplicate cpu feature",
+			spec:      HarvesterExtraSpec{CPUFeatures: []CPUFeatureSpec{{Name: "vmx"}, {Name: "vmx", Policy: "disable"}}},
+			errString: `invalid cpu_features[1]: duplicate name "vmx"`,
+		},
+		{
+			name:      "negative cpu threads",
+			spec:      HarvesterExtraSpec{CPUThreads: -2},
+			errString: "invalid cpu topology: cpu_sockets, cpu_cores and cpu_threads cannot be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.spec.Validate()
+			if tt.errString == "" {
+				require.Nil(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+}
+
+func TestFirmwareSettings(t *testing.T) {
+	firmware, tpm, hyperV := HarvesterExtraSpec{}.FirmwareSettings(false)
+	require.Equal(t, FirmwareBIOS, firmware)
+	require.False(t, tpm)
+	require.False(t, hyperV)
+
+	firmware, tpm, hyperV = HarvesterExtraSpec{}.FirmwareSettings(true)
+	require.Equal(t, FirmwareSecureBoot, firmware)
+	require.True(t, tpm)
+	require.True(t, hyperV)
+
+	off := false
+	firmware, tpm, hyperV = HarvesterExtraSpec{Firmware: FirmwareEFI, TPM: &off}.FirmwareSettings(true)
+	require.Equal(t, FirmwareEFI, firmware)
+	require.False(t, tpm)
+	require.True(t, hyperV)
+}
+
+func TestCPUTopology(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      HarvesterExtraSpec
+		vcpus     int
+		topology  [3]int
+		errString string
+	}{
+		{name: "default", vcpus: 4, topology: [3]int{1, 4, 1}},
+		{name: "cores from sockets and threads", spec: HarvesterExtraSpec{CPUSockets: 2, CPUThreads: 2}, vcpus: 8, topology: [3]int{2, 2, 2}},
+		{name: "explicit", spec: HarvesterExtraSpec{CPUSockets: 2, CPUCores: 4}, vcpus: 8, topology: [3]int{2, 4, 1}},
+		{name: "uneven split", spec: HarvesterExtraSpec{CPUThreads: 2}, vcpus: 3, errString: "3 vCPUs cannot be split into 1 sockets with 2 threads per core"},
+		{name: "wrong total", spec: HarvesterExtraSpec{CPUCores: 2, CPUThreads: 2}, vcpus: 8, errString: "1 sockets with 2 cores and 2 threads do not add up to 8 vCPUs"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sockets, cores, threads, err := tt.spec.CPUTopology(tt.vcpus)
+			if tt.errString != "" {
+				require.EqualError(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.topology, [3]int{sockets, cores, threads})
+		})
+	}
+}
+
+func TestNetworkInterfaces(t *testing.T) {
+	require.Equal(t, []NetworkSpec{
+		{NetworkName: "ci/vlan-100", NetworkAdapterType: "virtio", NetworkType: "masquerade"},
+	}, HarvesterExtraSpec{NetworkName: "ci/vlan-100"}.NetworkInterfaces())
+
+	require.Equal(t, []NetworkSpec{
+		{NetworkAdapterType: "virtio", NetworkType: "masquerade"},
+		{NetworkName: "ci/vlan-100", NetworkAdapterType: "e1000", NetworkType: "bridge", MACAddress: "02:00:00:00:01:00"},
+	}, HarvesterExtraSpec{Networks: []NetworkSpec{
+		{},
+		{NetworkName: "ci/vlan-100", NetworkAdapterType: "e1000", MACAddress: "02:00:00:00:01:00"},
+	}}.NetworkInterfaces())
+}
+
+func TestExtraSpecsJSONSchema(t *testing.T) {
+	data, err := ExtraSpecsJSONSchema()
+	require.NoError(t, err)
+
+	schema := &JSONSchema{}
+	require.NoError(t, json.Unmarshal([]byte(data), schema))
+	require.Equal(t, "object", schema.Type)
+	require.Equal(t, false, schema.AdditionalProperties)
+	require.Equal(t, []string{builder.DiskBusVirtio, builder.DiskBusSata, builder.DiskBusScsi}, schema.Properties["disk_connector_type"].Enum)
+	require.Equal(t, []string{"bridge", "masquerade"}, schema.Properties["network_type"].Enum)
+	require.NotEmpty(t, schema.Properties["network_name"].Description)
+}
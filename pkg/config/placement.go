@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// NodeSelectorRequirement matches node labels, as in a Kubernetes node affinity.
+type NodeSelectorRequirement struct {
+	Key      string   `json:"key" toml:"key" required:"true" description:"The node label key."`
+	Operator string   `json:"operator" toml:"operator" required:"true" enum:"In,NotIn,Exists,DoesNotExist,Gt,Lt" description:"How the label is matched against values."`
+	Values   []string `json:"values,omitempty" toml:"values" description:"Label values. Required for In and NotIn, a single integer for Gt and Lt, empty otherwise."`
+}
+
+// PreferredNodeAffinity is a weighted node affinity the scheduler tries to honor.
+type PreferredNodeAffinity struct {
+	Weight           int32                     `json:"weight" toml:"weight" required:"true" description:"Weight of the preference, 1 to 100."`
+	MatchExpressions []NodeSelectorRequirement `json:"match_expressions" toml:"match_expressions" required:"true" description:"Requirements a node has to meet, all of them."`
+}
+
+// Toleration lets runners be scheduled on nodes with a matching taint.
+type Toleration struct {
+	Key               string `json:"key,omitempty" toml:"key" description:"The taint key. Empty with operator Exists tolerates every taint."`
+	Operator          string `json:"operator,omitempty" toml:"operator" enum:"Exists,Equal" description:"Default is Equal."`
+	Value             string `json:"value,omitempty" toml:"value" description:"The taint value for operator Equal."`
+	Effect            string `json:"effect,omitempty" toml:"effect" enum:"NoSchedule,PreferNoSchedule,NoExecute" description:"The taint effect to tolerate. Empty tolerates all effects."`
+	TolerationSeconds *int64 `json:"toleration_seconds,omitempty" toml:"toleration_seconds" description:"How long a runner stays on a node tainted NoExecute."`
+}
+
+// TopologySpreadConstraint spreads the runners of a pool evenly across the
+// topology domains of a node label.
+type TopologySpreadConstraint struct {
+	TopologyKey       string `json:"topology_key" toml:"topology_key" required:"true" description:"Node label defining the domains, such as kubernetes.io/hostname or topology.kubernetes.io/zone."`
+	MaxSkew           int32  `json:"max_skew,omitempty" toml:"max_skew" description:"How many more runners of the pool a domain may run than the emptiest one. Default is 1."`
+	WhenUnsatisfiable string `json:"when_unsatisfiable,omitempty" toml:"when_unsatisfiable" enum:"DoNotSchedule,ScheduleAnyway" description:"What the scheduler does when the skew cannot be kept. Default is DoNotSchedule."`
+}
+
+// Placement decides which nodes runners are scheduled on. It is set in the
+// extra specs of a pool and, as a default for every pool, in the provider
+// config.
+type Placement struct {
+	NodeSelector          map[string]string         `json:"node_selector,omitempty" toml:"node_selector" description:"Node labels a node needs to run runners."`
+	RequiredNodeAffinity  []NodeSelectorRequirement `json:"required_node_affinity,omitempty" toml:"required_node_affinity" description:"Requirements a node has to meet, all of them, to run runners."`
+	PreferredNodeAffinity []PreferredNodeAffinity   `json:"preferred_node_affinity,omitempty" toml:"preferred_node_affinity" description:"Node affinities the scheduler tries to honor."`
+	Tolerations           []Toleration              `json:"tolerations,omitempty" toml:"tolerations" description:"Taints runners tolerate."`
+	// Spreading is keyed on the pool ID label, so it only ever spreads the
+	// runners of one pool.
+	Spread                    string                     `json:"spread,omitempty" toml:"spread" enum:"soft,hard" description:"Spread the runners of a pool across topology domains with pod anti-affinity. soft prefers separate domains, hard requires them. Off unless set."`
+	SpreadTopologyKey         string                     `json:"spread_topology_key,omitempty" toml:"spread_topology_key" description:"Node label defining the domains spread places runners in, such as topology.kubernetes.io/zone. Default is kubernetes.io/hostname."`
+	TopologySpreadConstraints []TopologySpreadConstraint `json:"topology_spread_constraints,omitempty" toml:"topology_spread_constraints" description:"Topology spread constraints on the runners of a pool."`
+}
+
+// WithDefaults returns the placement with every setting it leaves empty taken
+// from def.
+func (p Placement) WithDefaults(def Placement) Placement {
+	if p.NodeSelector == nil {
+		p.NodeSelector = def.NodeSelector
+	}
+	if p.RequiredNodeAffinity == nil {
+		p.RequiredNodeAffinity = def.RequiredNodeAffinity
+	}
+	if p.PreferredNodeAffinity == nil {
+		p.PreferredNodeAffinity = def.PreferredNodeAffinity
+	}
+	if p.Tolerations == nil {
+		p.Tolerations = def.Tolerations
+	}
+	if p.Spread == "" {
+		p.Spread = def.Spread
+	}
+	if p.SpreadTopologyKey == "" {
+		p.SpreadTopologyKey = def.SpreadTopologyKey
+	}
+	if p.TopologySpreadConstraints == nil {
+		p.TopologySpreadConstraints = def.TopologySpreadConstraints
+	}
+	return p
+}
+
+func (p Placement) Validate() error {
+	for key, value := range p.NodeSelector {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid node_selector key %q: %s", key, errs[0])
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid node_selector value %q: %s", value, errs[0])
+		}
+	}
+	for i, req := range p.RequiredNodeAffinity {
+		if err := req.Validate(); err != nil {
+			return fmt.Errorf("invalid required_node_affinity[%d]: %w", i, err)
+		}
+	}
+	for i, pref := range p.PreferredNodeAffinity {
+		if pref.Weight < 1 || pref.Weight > 100 {
+			return fmt.Errorf("invalid preferred_node_affinity[%d]: weight must be between 1 and 100", i)
+		}
+		if len(pref.MatchExpressions) == 0 {
+			return fmt.Errorf("invalid preferred_node_affinity[%d]: missing match_expressions", i)
+		}
+		for j, req := range pref.MatchExpressions {
+			if err := req.Validate(); err != nil {
+				return fmt.Errorf("invalid preferred_node_affinity[%d].match_expressions[%d]: %w", i, j, err)
+			}
+		}
+	}
+	for i, toleration := range p.Tolerations {
+		if err := toleration.Validate(); err != nil {
+			return fmt.Errorf("invalid tolerations[%d]: %w", i, err)
+		}
+	}
+	if p.SpreadTopologyKey != "" {
+		if errs := validation.IsQualifiedName(p.SpreadTopologyKey); len(errs) > 0 {
+			return fmt.Errorf("invalid spread_topology_key %q: %s", p.SpreadTopologyKey, errs[0])
+		}
+	}
+	for i, constraint := range p.TopologySpreadConstraints {
+		if errs := validation.IsQualifiedName(constraint.TopologyKey); len(errs) > 0 {
+			return fmt.Errorf("invalid topology_spread_constraints[%d]: invalid topology_key %q: %s", i, constraint.TopologyKey, errs[0])
+		}
+		if constraint.MaxSkew < 0 {
+			return fmt.Errorf("invalid topology_spread_constraints[%d]: invalid max_skew: %d", i, constraint.MaxSkew)
+		}
+	}
+	return nil
+}
+
+func (r NodeSelectorRequirement) Validate() error {
+	if errs := validation.IsQualifiedName(r.Key); len(errs) > 0 {
+		return fmt.Errorf("invalid key %q: %s", r.Key, errs[0])
+	}
+	switch r.Operator {
+	case "In", "NotIn":
+		if len(r.Values) == 0 {
+			return fmt.Errorf("operator %s needs values", r.Operator)
+		}
+	case "Exists", "DoesNotExist":
+		if len(r.Values) > 0 {
+			return fmt.Errorf("operator %s takes no values", r.Operator)
+		}
+	case "Gt", "Lt":
+		if len(r.Values) != 1 {
+			return fmt.Errorf("operator %s needs a single value", r.Operator)
+		}
+		if _, err := strconv.ParseInt(r.Values[0], 10, 64); err != nil {
+			return fmt.Errorf("operator %s needs an integer value, got %s", r.Operator, r.Values[0])
+		}
+	default:
+		return fmt.Errorf("invalid operator: %s", r.Operator)
+	}
+	return nil
+}
+
+func (t Toleration) Validate() error {
+	if t.Operator == "Exists" && t.Value != "" {
+		return fmt.Errorf("operator Exists takes no value")
+	}
+	if t.Key == "" && t.Operator != "Exists" {
+		return fmt.Errorf("an empty key needs operator Exists")
+	}
+	if t.TolerationSeconds != nil && t.Effect != "NoExecute" {
+		return fmt.Errorf("toleration_seconds is only valid with effect NoExecute")
+	}
+	return nil
+}
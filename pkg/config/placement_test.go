@@ -0,0 +1,130 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlacementValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		placement Placement
+		errString string
+	}{
+		{
+			name: "valid placement",
+			placement: Placement{
+				NodeSelector:         map[string]string{"ci.example.com/dedicated": "true"},
+				RequiredNodeAffinity: []NodeSelectorRequirement{{Key: "kubernetes.io/arch", Operator: "In", Values: []string{"amd64"}}},
+				PreferredNodeAffinity: []PreferredNodeAffinity{
+					{Weight: 50, MatchExpressions: []NodeSelectorRequirement{{Key: "ci.example.com/nvme", Operator: "Exists"}}},
+				},
+				Tolerations: []Toleration{{Key: "ci.example.com/dedicated", Value: "true", Effect: "NoSchedule"}},
+			},
+			errString: "",
+		},
+		{
+			name:      "In without values",
+			placement: Placement{RequiredNodeAffinity: []NodeSelectorRequirement{{Key: "kubernetes.io/arch", Operator: "In"}}},
+			errString: "invalid required_node_affinity[0]: operator In needs values",
+		},
+		{
+			name:      "Gt with text",
+			placement: Placement{RequiredNodeAffinity: []NodeSelectorRequirement{{Key: "ci.example.com/cores", Operator: "Gt", Values: []string{"many"}}}},
+			errString: "invalid required_node_affinity[0]: operator Gt needs an integer value, got many",
+		},
+		{
+			name: "weight out of range",
+			placement: Placement{PreferredNodeAffinity: []PreferredNodeAffinity{
+				{Weight: 0, MatchExpressions: []NodeSelectorRequirement{{Key: "ci.example.com/nvme", Operator: "Exists"}}},
+			}},
+			errString: "invalid preferred_node_affinity[0]: weight must be between 1 and 100",
+		},
+		{
+			name:      "Exists with value",
+			placement: Placement{Tolerations: []Toleration{{Key: "ci.example.com/dedicated", Operator: "Exists", Value: "true"}}},
+			errString: "invalid tolerations[0]: operator Exists takes no value",
+		},
+		{
+			name: "valid spreading",
+			placement: Placement{
+				Spread:                    "hard",
+				SpreadTopologyKey:         "topology.kubernetes.io/zone",
+				TopologySpreadConstraints: []TopologySpreadConstraint{{TopologyKey: "kubernetes.io/hostname", MaxSkew: 2}},
+			},
+			errString: "",
+		},
+		{
+			name:      "negative max skew",
+			placement: Placement{TopologySpreadConstraints: []TopologySpreadConstraint{{TopologyKey: "kubernetes.io/hostname", MaxSkew: -1}}},
+			errString: "invalid topology_spread_constraints[0]: invalid max_skew: -1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.placement.Validate()
+			if tt.errString == "" {
+				require.Nil(t, err)
+			} else {
+				require.EqualError(t, err, tt.errString)
+			}
+		})
+	}
+
+	err := Placement{NodeSelector: map[string]string{"not a key": "true"}}.Validate()
+	require.ErrorContains(t, err, `invalid node_selector key "not a key"`)
+}
+
+func TestPlacementWithDefaults(t *testing.T) {
+	def := Placement{
+		NodeSelector: map[string]string{"ci.example.com/dedicated": "true"},
+		Tolerations:  []Toleration{{Key: "ci.example.com/dedicated", Operator: "Exists"}},
+	}
+	pool := Placement{NodeSelector: map[string]string{"ci.example.com/gpu": "true"}}
+
+	require.Equal(t, Placement{
+		NodeSelector: map[string]string{"ci.example.com/gpu": "true"},
+		Tolerations:  []Toleration{{Key: "ci.example.com/dedicated", Operator: "Exists"}},
+	}, pool.WithDefaults(def))
+}
+
+func TestPlacementDecoding(t *testing.T) {
+	spec := HarvesterExtraSpec{}
+	require.NoError(t, json.Unmarshal([]byte(`{"node_selector": {"ci.example.com/dedicated": "true"}, "tolerations": [{"key": "ci.example.com/dedicated", "operator": "Exists"}]}`), &spec))
+	require.Equal(t, map[string]string{"ci.example.com/dedicated": "true"}, spec.NodeSelector)
+	require.Len(t, spec.Tolerations, 1)
+	require.EqualError(t, HarvesterExtraSpec{Placement: Placement{Tolerations: []Toleration{{Effect: "Evict"}}}}.Validate(), "invalid tolerations[0].effect: Evict")
+
+	f, err := os.CreateTemp("", "test-config.toml")
+	require.NoError(t, err, "Failed to create temp file")
+	defer os.Remove(f.Name())
+
+	f.WriteString(`namespace = "garm-runners"
+
+[node_selector]
+	"ci.example.com/dedicated" = "true"
+
+[[required_node_affinity]]
+	key = "kubernetes.io/arch"
+	operator = "In"
+	values = ["amd64"]
+
+[credentials]
+	kubeconfig = "/home/vscode/.kubeconfig"`)
+
+	c, err := NewProviderConfig(f.Name())
+	require.NoError(t, err, "Failed to create config struct")
+	require.Equal(t, map[string]string{"ci.example.com/dedicated": "true"}, c.NodeSelector)
+	require.Equal(t, []NodeSelectorRequirement{{Key: "kubernetes.io/arch", Operator: "In", Values: []string{"amd64"}}}, c.RequiredNodeAffinity)
+
+	data, err := ExtraSpecsJSONSchema()
+	require.NoError(t, err)
+	schema := &JSONSchema{}
+	require.NoError(t, json.Unmarshal([]byte(data), schema))
+	require.Equal(t, "object", schema.Properties["node_selector"].Type)
+	require.Equal(t, []string{"key", "operator"}, schema.Properties["required_node_affinity"].Items.Required)
+}
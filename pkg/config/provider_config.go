@@ -1,16 +1,18 @@
 package config
 
 import (
-	"context"
 	"encoding/base64"
 	"fmt"
 	"os"
+	"reflect"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 type Credentials struct {
-	KubeConfig string `toml:"kubeconfig"`
+	KubeConfig string `toml:"kubeconfig" required:"true" description:"Path to the kubeconfig of the Harvester cluster, or the kubeconfig itself encoded as base64."`
 }
 
 func (c Credentials) Validate() error {
@@ -18,7 +20,8 @@ func (c Credentials) Validate() error {
 		return fmt.Errorf("missing kubeconfig")
 	}
 
-	_, err := base64.StdEncoding.DecodeString(c.KubeConfig); if err == nil {
+	_, err := base64.StdEncoding.DecodeString(c.KubeConfig)
+	if err == nil {
 		return nil
 	}
 	if _, err := os.Stat(c.KubeConfig); err != nil {
@@ -28,12 +31,52 @@ func (c Credentials) Validate() error {
 	return nil
 }
 
-// TODO: Add disk size to override VM image size disk.
+const (
+	DefaultShutdownGracePeriod = 2 * time.Minute
+	DefaultStopTimeout         = 3 * time.Minute
+	DefaultDeleteConcurrency   = 4
+	DefaultDeleteTimeout       = 5 * time.Minute
+)
+
+// Duration is a time.Duration read from a string such as "90s" or "5m".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	value, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(value)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// OrDefault returns the duration, or def if it is not set.
+func (d Duration) OrDefault(def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return time.Duration(d)
+}
+
 type Config struct {
-	Credentials      Credentials `toml:"credentials"`
-	Namespace        string      `toml:"namespace"`
+	Credentials         Credentials `toml:"credentials" required:"true" description:"Credentials used to reach the Harvester cluster."`
+	Namespace           string      `toml:"namespace" required:"true" description:"The namespace runner VMs and their resources are created in."`
+	ShutdownGracePeriod Duration    `toml:"shutdown_grace_period" description:"How long a runner gets to shut down after a graceful stop before it is powered off. Default is 2m."`
+	StopTimeout         Duration    `toml:"stop_timeout" description:"How long a graceful stop waits for the runner to power off. Default is 3m. Cannot be shorter than shutdown_grace_period."`
+	DeleteConcurrency   int         `toml:"delete_concurrency" description:"How many runners RemoveAllInstances deletes at the same time. Default is 4."`
+	DeleteWait          bool        `toml:"delete_wait" description:"Wait in DeleteInstance and RemoveAllInstances until the VM, its VMI, its virt-launcher pod and its removed PVCs are gone."`
+	DeleteTimeout       Duration    `toml:"delete_timeout" description:"How long DeleteInstance and RemoveAllInstances wait for a runner to be torn down when delete_wait is set. Default is 5m."`
+	BootDiskSize        string      `toml:"boot_disk_size" description:"Default size of the root disk, such as 40Gi, for pools without the boot_disk_size extra spec. Defaults to the disk of the flavor."`
+	// Placement is the default placement of pools that do not set their own.
+	Placement
 }
 
+const configSchemaID = "http://cloudbase.it/garm-provider-harvester/schemas/config#"
+
 func NewProviderConfig(providerConfig string) (config Config, err error) {
 	if _, err := toml.DecodeFile(providerConfig, &config); err != nil {
 		return Config{}, fmt.Errorf("error decoding config: %w", err)
@@ -51,25 +94,48 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("missing namespaces")
 	}
 
-	return nil
-}
+	if c.ShutdownGracePeriod < 0 {
+		return fmt.Errorf("invalid shutdown_grace_period: %s", time.Duration(c.ShutdownGracePeriod))
+	}
 
-// GetConfigJSONSchema implements executionv011.ExternalProvider.
-func (c *Config) GetConfigJSONSchema(ctx context.Context) (string, error) {
-	return "", nil
-}
+	if c.StopTimeout < 0 {
+		return fmt.Errorf("invalid stop_timeout: %s", time.Duration(c.StopTimeout))
+	}
 
-// GetExtraSpecsJSONSchema implements executionv011.ExternalProvider.
-func (c *Config) GetExtraSpecsJSONSchema(ctx context.Context) (string, error) {
-	return "", nil
-}
+	gracePeriod := c.ShutdownGracePeriod.OrDefault(DefaultShutdownGracePeriod)
+	if stopTimeout := c.StopTimeout.OrDefault(DefaultStopTimeout); stopTimeout < gracePeriod {
+		return fmt.Errorf("invalid stop_timeout: %s is shorter than shutdown_grace_period %s", stopTimeout, gracePeriod)
+	}
 
-// GetSupportedInterfaceVersions implements executionv011.ExternalProvider.
-func (c *Config) GetSupportedInterfaceVersions(ctx context.Context) []string {
-	return []string{"v0.1.0", "v0.1.1"}
-}
+	if c.BootDiskSize != "" {
+		if _, err := resource.ParseQuantity(c.BootDiskSize); err != nil {
+			return fmt.Errorf("invalid boot_disk_size: %s", c.BootDiskSize)
+		}
+	}
+
+	if c.DeleteTimeout < 0 {
+		return fmt.Errorf("invalid delete_timeout: %s", time.Duration(c.DeleteTimeout))
+	}
+
+	if c.DeleteConcurrency < 0 {
+		return fmt.Errorf("invalid delete_concurrency: %d", c.DeleteConcurrency)
+	}
+
+	if err := validateEnums(c, "toml"); err != nil {
+		return err
+	}
+
+	if err := c.Placement.Validate(); err != nil {
+		return err
+	}
 
-// ValidatePoolInfo implements executionv011.ExternalProvider.
-func (c *Config) ValidatePoolInfo(ctx context.Context, image string, flavor string, providerConfig string, extraspecs string) error {
 	return nil
 }
+
+// ConfigJSONSchema returns the JSON schema of the TOML provider config.
+func ConfigJSONSchema() (string, error) {
+	schema := schemaFor(reflect.TypeOf(Config{}), "toml")
+	schema.Schema = configSchemaID
+	schema.Description = "Schema defining the provider config of the Garm Harvester Provider"
+	return marshalSchema(schema)
+}
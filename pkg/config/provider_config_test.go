@@ -2,8 +2,10 @@ package config
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -77,4 +79,40 @@ func TestNewConfig(t *testing.T) {
 	require.Equal(t, c.Namespace, "garm-runners")
 	require.Equal(t, c.Credentials.KubeConfig, "/home/vscode/.kubeconfig")
 
-}
\ No newline at end of file
+}
+
+func TestConfigJSONSchema(t *testing.T) {
+	data, err := ConfigJSONSchema()
+	require.NoError(t, err)
+
+	schema := &JSONSchema{}
+	require.NoError(t, json.Unmarshal([]byte(data), schema))
+	require.Equal(t, []string{"credentials", "namespace"}, schema.Required)
+	require.Equal(t, "string", schema.Properties["namespace"].Type)
+	require.Equal(t, []string{"kubeconfig"}, schema.Properties["credentials"].Required)
+}
+
+func TestConfigDurations(t *testing.T) {
+	f, err := os.CreateTemp("", "test-config.toml")
+	require.NoError(t, err, "Failed to create temp file")
+	defer os.Remove(f.Name())
+
+	f.WriteString(`namespace = "garm-runners"
+shutdown_grace_period = "90s"
+
+[credentials]
+	kubeconfig = "/home/vscode/.kubeconfig"`)
+
+	c, err := NewProviderConfig(f.Name())
+	require.NoError(t, err, "Failed to create config struct")
+
+	require.Equal(t, 90*time.Second, c.ShutdownGracePeriod.OrDefault(DefaultShutdownGracePeriod))
+	require.Equal(t, DefaultStopTimeout, c.StopTimeout.OrDefault(DefaultStopTimeout))
+
+	c.StopTimeout = Duration(-time.Second)
+	c.Credentials.KubeConfig = base64.StdEncoding.EncodeToString([]byte("hello"))
+	require.EqualError(t, c.Validate(), "invalid stop_timeout: -1s")
+
+	c.StopTimeout = Duration(time.Minute)
+	require.EqualError(t, c.Validate(), "invalid stop_timeout: 1m0s is shorter than shutdown_grace_period 1m30s")
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// JSONSchema is the subset of JSON schema the provider emits for GARM.
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	AdditionalProperties any                    `json:"additionalProperties,omitempty"`
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// schemaFor builds a JSON schema from a Go type. Field names are read from
+// tagName (json or toml), descriptions from the "description" tag, allowed
+// values from the comma separated "enum" tag and required fields from the
+// "required" tag. Types decoded from text, such as Duration, are strings.
+// Fields of embedded structs are inlined.
+func schemaFor(t reflect.Type, tagName string) *JSONSchema {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return &JSONSchema{Type: "string"}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		s := &JSONSchema{
+			Type:                 "object",
+			Properties:           map[string]*JSONSchema{},
+			AdditionalProperties: false,
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if isInlined(field, tagName) {
+				inlined := schemaFor(field.Type, tagName)
+				for name, prop := range inlined.Properties {
+					s.Properties[name] = prop
+				}
+				s.Required = append(s.Required, inlined.Required...)
+				continue
+			}
+			name := fieldName(field, tagName)
+			if name == "" {
+				continue
+			}
+			prop := schemaFor(field.Type, tagName)
+			prop.Description = field.Tag.Get("description")
+			if enum := field.Tag.Get("enum"); enum != "" {
+				prop.Enum = strings.Split(enum, ",")
+			}
+			s.Properties[name] = prop
+			if field.Tag.Get("required") == "true" {
+				s.Required = append(s.Required, name)
+			}
+		}
+		return s
+	case reflect.Slice, reflect.Array:
+		return &JSONSchema{Type: "array", Items: schemaFor(t.Elem(), tagName)}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: schemaFor(t.Elem(), tagName)}
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	default:
+		return &JSONSchema{Type: "string"}
+	}
+}
+
+// isInlined reports whether field is an embedded struct without a name, whose
+// fields are decoded as fields of the embedding struct.
+func isInlined(field reflect.StructField, tagName string) bool {
+	name, _, _ := strings.Cut(field.Tag.Get(tagName), ",")
+	return field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct
+}
+
+func fieldName(field reflect.StructField, tagName string) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(field.Tag.Get(tagName), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// validateEnums checks every non empty string field carrying an "enum" tag
+// against its allowed values, descending into nested structs and slices.
+func validateEnums(v any, tagName string) error {
+	return validateEnumsValue(reflect.ValueOf(v), tagName, "")
+}
+
+func validateEnumsValue(v reflect.Value, tagName string, path string) error {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if isInlined(field, tagName) {
+				if err := validateEnumsValue(v.Field(i), tagName, path); err != nil {
+					return err
+				}
+				continue
+			}
+			name := fieldName(field, tagName)
+			if name == "" {
+				continue
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			value := v.Field(i)
+			if enum := field.Tag.Get("enum"); enum != "" && value.Kind() == reflect.String {
+				if value.String() != "" && !slices.Contains(strings.Split(enum, ","), value.String()) {
+					return fmt.Errorf("invalid %s: %s", name, value.String())
+				}
+				continue
+			}
+			if err := validateEnumsValue(value, tagName, name); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := validateEnumsValue(v.Index(i), tagName, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func marshalSchema(s *JSONSchema) (string, error) {
+	data, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JSON schema: %w", err)
+	}
+	return string(data), nil
+}
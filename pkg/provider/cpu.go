@@ -0,0 +1,72 @@
+package provider
+
+import (
+	"fmt"
+	"garm-provider-harvester/pkg/config"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+// checkCPUAndMemory checks that the CPU topology of a pool adds up to the
+// vCPUs of its flavor and that its memory fills whole hugepages.
+func checkCPUAndMemory(extraSpec *config.HarvesterExtraSpec, vcpus int, memory string) error {
+	if _, _, _, err := extraSpec.CPUTopology(vcpus); err != nil {
+		return fmt.Errorf("invalid cpu topology: %w", err)
+	}
+	if extraSpec.Hugepages != "" {
+		mem, err := resource.ParseQuantity(memory)
+		if err != nil {
+			return fmt.Errorf("invalid memory %s: %w", memory, err)
+		}
+		pageSize := resource.MustParse(extraSpec.Hugepages)
+		if mem.Value()%pageSize.Value() != 0 {
+			return fmt.Errorf("memory %s is not a multiple of the hugepage size %s", memory, extraSpec.Hugepages)
+		}
+	}
+	return nil
+}
+
+// applyCPU sets the CPU topology, model, features and placement and the
+// hugepages of a runner VM. A VM with dedicated CPUs needs the guaranteed QoS
+// class, so its CPU and memory requests are set to its limits.
+func applyCPU(spec *kubevirtv1.VirtualMachineInstanceSpec, extraSpec *config.HarvesterExtraSpec, vcpus int) error {
+	if spec.Domain.CPU == nil {
+		spec.Domain.CPU = &kubevirtv1.CPU{}
+	}
+	cpu := spec.Domain.CPU
+	if extraSpec.HasCPUTopology() {
+		sockets, cores, threads, err := extraSpec.CPUTopology(vcpus)
+		if err != nil {
+			return err
+		}
+		cpu.Sockets, cpu.Cores, cpu.Threads = uint32(sockets), uint32(cores), uint32(threads) // nolint:gosec
+	}
+	cpu.Model = extraSpec.CPUModel
+	for _, feature := range extraSpec.CPUFeatures {
+		policy := feature.Policy
+		if policy == "" {
+			policy = "require"
+		}
+		cpu.Features = append(cpu.Features, kubevirtv1.CPUFeature{Name: feature.Name, Policy: policy})
+	}
+
+	if extraSpec.DedicatedCPUPlacement {
+		cpu.DedicatedCPUPlacement = true
+		limits := spec.Domain.Resources.Limits
+		if spec.Domain.Resources.Requests == nil {
+			spec.Domain.Resources.Requests = corev1.ResourceList{}
+		}
+		spec.Domain.Resources.Requests[corev1.ResourceCPU] = limits[corev1.ResourceCPU]
+		spec.Domain.Resources.Requests[corev1.ResourceMemory] = limits[corev1.ResourceMemory]
+	}
+
+	if extraSpec.Hugepages != "" {
+		if spec.Domain.Memory == nil {
+			spec.Domain.Memory = &kubevirtv1.Memory{}
+		}
+		spec.Domain.Memory.Hugepages = &kubevirtv1.Hugepages{PageSize: extraSpec.Hugepages}
+	}
+	return nil
+}
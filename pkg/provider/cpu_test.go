@@ -0,0 +1,47 @@
+package provider
+
+import (
+	"garm-provider-harvester/pkg/config"
+	"testing"
+
+	"github.com/harvester/harvester/pkg/builder"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+func TestCheckCPUAndMemory(t *testing.T) {
+	require.NoError(t, checkCPUAndMemory(&config.HarvesterExtraSpec{Hugepages: "1Gi"}, 4, "8Gi"))
+	require.EqualError(t, checkCPUAndMemory(&config.HarvesterExtraSpec{Hugepages: "1Gi"}, 4, "1536Mi"), "memory 1536Mi is not a multiple of the hugepage size 1Gi")
+	require.EqualError(t, checkCPUAndMemory(&config.HarvesterExtraSpec{CPUSockets: 3}, 4, "8Gi"), "invalid cpu topology: 4 vCPUs cannot be split into 3 sockets with 1 threads per core")
+}
+
+func TestApplyCPU(t *testing.T) {
+	vm, err := builder.NewVMBuilder("garm-provider").Name("garm-runner").CPU(8).Memory("16Gi").VM()
+	require.NoError(t, err)
+	spec := &vm.Spec.Template.Spec
+
+	require.NoError(t, applyCPU(spec, &config.HarvesterExtraSpec{}, 8))
+	require.Equal(t, uint32(8), spec.Domain.CPU.Cores)
+	require.Zero(t, spec.Domain.CPU.Sockets)
+	require.Nil(t, spec.Domain.Resources.Requests)
+
+	extraSpec := &config.HarvesterExtraSpec{
+		CPUSockets:            2,
+		CPUThreads:            2,
+		CPUModel:              "host-passthrough",
+		CPUFeatures:           []config.CPUFeatureSpec{{Name: "vmx"}, {Name: "svm", Policy: "disable"}},
+		DedicatedCPUPlacement: true,
+		Hugepages:             "2Mi",
+	}
+	require.NoError(t, applyCPU(spec, extraSpec, 8))
+
+	cpu := spec.Domain.CPU
+	require.Equal(t, [3]uint32{2, 2, 2}, [3]uint32{cpu.Sockets, cpu.Cores, cpu.Threads})
+	require.Equal(t, "host-passthrough", cpu.Model)
+	require.Equal(t, []kubevirtv1.CPUFeature{{Name: "vmx", Policy: "require"}, {Name: "svm", Policy: "disable"}}, cpu.Features)
+	require.True(t, cpu.DedicatedCPUPlacement)
+	require.Equal(t, spec.Domain.Resources.Limits[corev1.ResourceCPU], spec.Domain.Resources.Requests[corev1.ResourceCPU])
+	require.Equal(t, spec.Domain.Resources.Limits[corev1.ResourceMemory], spec.Domain.Resources.Requests[corev1.ResourceMemory])
+	require.Equal(t, "2Mi", spec.Domain.Memory.Hugepages.PageSize)
+}
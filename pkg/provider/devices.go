@@ -0,0 +1,87 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"garm-provider-harvester/pkg/config"
+
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/harvester/harvester/pkg/builder"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+// addDevices passes the GPUs and PCI devices of a pool through to a VM, one
+// device entry for every device a runner gets.
+func addDevices(vmBuilder *builder.VMBuilder, gpus, hostDevices []config.DeviceSpec) *builder.VMBuilder {
+	n := 0
+	for _, gpu := range gpus {
+		for range gpu.DeviceCount() {
+			vmBuilder = vmBuilder.GPU(fmt.Sprintf("gpu-%d", n), gpu.DeviceName, "", nil)
+			n++
+		}
+	}
+	n = 0
+	for _, device := range hostDevices {
+		for range device.DeviceCount() {
+			vmBuilder = vmBuilder.HostDevice(fmt.Sprintf("hostdevice-%d", n), device.DeviceName, "")
+			n++
+		}
+	}
+	return vmBuilder
+}
+
+// unpermittedDevices returns the devices that are not in the permitted host
+// devices of the KubeVirt config. GPUs are either vGPU profiles or whole GPUs,
+// so they can be mediated or PCI devices.
+func unpermittedDevices(permitted *kubevirtv1.PermittedHostDevices, gpus, hostDevices []config.DeviceSpec) []string {
+	pci := map[string]bool{}
+	mediated := map[string]bool{}
+	if permitted != nil {
+		for _, device := range permitted.PciHostDevices {
+			pci[device.ResourceName] = true
+		}
+		for _, device := range permitted.MediatedDevices {
+			mediated[device.ResourceName] = true
+		}
+	}
+
+	var res []string
+	for _, gpu := range gpus {
+		if !pci[gpu.DeviceName] && !mediated[gpu.DeviceName] {
+			res = append(res, gpu.DeviceName)
+		}
+	}
+	for _, device := range hostDevices {
+		if !pci[device.DeviceName] {
+			res = append(res, device.DeviceName)
+		}
+	}
+	return res
+}
+
+// validateDevices checks that the GPUs and PCI devices of a pool are permitted
+// host devices. Harvester adds a device there once it is enabled for
+// passthrough, and KubeVirt refuses to start a VM asking for any other device.
+func (h *HarvesterProvider) validateDevices(ctx context.Context, gpus, hostDevices []config.DeviceSpec) error {
+	if len(gpus) == 0 && len(hostDevices) == 0 {
+		return nil
+	}
+
+	kubeVirts, err := h.HarvesterClient.KubevirtV1().KubeVirts("").List(ctx, v1.ListOptions{})
+	if err != nil {
+		return apiError(err, "failed to list kubevirt configs")
+	}
+	var permitted *kubevirtv1.PermittedHostDevices
+	for _, kubeVirt := range kubeVirts.Items {
+		if kubeVirt.Spec.Configuration.PermittedHostDevices != nil {
+			permitted = kubeVirt.Spec.Configuration.PermittedHostDevices
+			break
+		}
+	}
+
+	if devices := unpermittedDevices(permitted, gpus, hostDevices); len(devices) > 0 {
+		return fmt.Errorf("devices %v are not enabled for passthrough: %w", devices, garmErrors.ErrBadRequest)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package provider
+
+import (
+	"garm-provider-harvester/pkg/config"
+	"testing"
+
+	"github.com/harvester/harvester/pkg/builder"
+	"github.com/stretchr/testify/require"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+func TestAddDevices(t *testing.T) {
+	gpus := []config.DeviceSpec{{DeviceName: "nvidia.com/NVIDIA_A2-2Q", Count: 2}}
+	hostDevices := []config.DeviceSpec{{DeviceName: "intel.com/QAT"}}
+	vm, err := addDevices(builder.NewVMBuilder("garm-provider").Name("garm-runner"), gpus, hostDevices).VM()
+	require.NoError(t, err)
+
+	devices := vm.Spec.Template.Spec.Domain.Devices
+	require.Len(t, devices.GPUs, 2)
+	require.Equal(t, "gpu-1", devices.GPUs[1].Name)
+	require.Equal(t, "nvidia.com/NVIDIA_A2-2Q", devices.GPUs[1].DeviceName)
+	require.Len(t, devices.HostDevices, 1)
+	require.Equal(t, "hostdevice-0", devices.HostDevices[0].Name)
+	require.Equal(t, "intel.com/QAT", devices.HostDevices[0].DeviceName)
+}
+
+func TestUnpermittedDevices(t *testing.T) {
+	permitted := &kubevirtv1.PermittedHostDevices{
+		PciHostDevices: []kubevirtv1.PciHostDevice{
+			{ResourceName: "nvidia.com/GA107GL_A2_PCIE_16GB"},
+			{ResourceName: "intel.com/QAT"},
+		},
+		MediatedDevices: []kubevirtv1.MediatedHostDevice{
+			{ResourceName: "nvidia.com/NVIDIA_A2-2Q"},
+		},
+	}
+	gpus := []config.DeviceSpec{
+		{DeviceName: "nvidia.com/NVIDIA_A2-2Q"},
+		{DeviceName: "nvidia.com/GA107GL_A2_PCIE_16GB"},
+		{DeviceName: "nvidia.com/NVIDIA_A2-4Q"},
+	}
+	hostDevices := []config.DeviceSpec{
+		{DeviceName: "intel.com/QAT"},
+		{DeviceName: "nvidia.com/NVIDIA_A2-2Q"},
+	}
+
+	require.Equal(t, []string{"nvidia.com/NVIDIA_A2-4Q", "nvidia.com/NVIDIA_A2-2Q"}, unpermittedDevices(permitted, gpus, hostDevices))
+	require.Equal(t, []string{"intel.com/QAT"}, unpermittedDevices(nil, nil, hostDevices[:1]))
+}
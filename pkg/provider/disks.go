@@ -0,0 +1,112 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"garm-provider-harvester/pkg/config"
+	"garm-provider-harvester/pkg/utils"
+	"strconv"
+
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/harvester/harvester/pkg/builder"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+func diskBus(disk config.DiskSpec) string {
+	if disk.Bus == "" {
+		return builder.DiskBusVirtio
+	}
+	return disk.Bus
+}
+
+// diskMounts returns the extra disks cloud-init has to format and mount.
+func diskMounts(disks []config.DiskSpec) []utils.DiskMount {
+	var mounts []utils.DiskMount
+	for _, disk := range disks {
+		if disk.MountPath == "" {
+			continue
+		}
+		mounts = append(mounts, utils.DiskMount{
+			Device:     utils.DiskDevicePath(disk.Name, diskBus(disk)),
+			MountPath:  disk.MountPath,
+			Filesystem: disk.Filesystem,
+		})
+	}
+	return mounts
+}
+
+// addExtraDisks attaches the extra disks of a pool to a VM. The volumes of pvc
+// disks carry their auto-delete policy, which vpcsToRemove reads back when the
+// runner is deleted.
+func (h *HarvesterProvider) addExtraDisks(vmBuilder *builder.VMBuilder, disks []config.DiskSpec) *builder.VMBuilder {
+	for _, disk := range disks {
+		switch disk.DiskType() {
+		case config.DiskTypePVC:
+			pvcOption := &builder.PersistentVolumeClaimOption{
+				VolumeMode: corev1.PersistentVolumeBlock,
+				AccessMode: corev1.ReadWriteMany,
+				Annotations: map[string]string{
+					autoDeleteAnnotation: strconv.FormatBool(disk.ShouldAutoDelete()),
+					fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, controllerIdConst): h.ControllerID,
+				},
+			}
+			if disk.StorageClass != "" {
+				pvcOption.StorageClassName = &disk.StorageClass
+			}
+			vmBuilder = vmBuilder.PVCDisk(disk.Name, diskBus(disk), false, false, 0, disk.Size, "", pvcOption)
+		case config.DiskTypeEmptyDisk:
+			vmBuilder = vmBuilder.Disk(disk.Name, diskBus(disk), false, 0).Volume(disk.Name, kubevirtv1.Volume{
+				Name: disk.Name,
+				VolumeSource: kubevirtv1.VolumeSource{
+					EmptyDisk: &kubevirtv1.EmptyDiskSource{Capacity: resource.MustParse(disk.Size)},
+				},
+			})
+		case config.DiskTypeEphemeral:
+			vmBuilder = vmBuilder.Disk(disk.Name, diskBus(disk), false, 0).Volume(disk.Name, kubevirtv1.Volume{
+				Name: disk.Name,
+				VolumeSource: kubevirtv1.VolumeSource{
+					Ephemeral: &kubevirtv1.EphemeralVolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: disk.ClaimName},
+					},
+				},
+			})
+		}
+	}
+	return vmBuilder
+}
+
+// setDiskSerials sets the serial of every extra disk to its name, which is how
+// cloud-init and jobs find the disk in the guest.
+func setDiskSerials(vm *kubevirtv1.VirtualMachine, disks []config.DiskSpec) {
+	serials := make(map[string]bool, len(disks))
+	for _, disk := range disks {
+		serials[disk.Name] = true
+	}
+	devices := vm.Spec.Template.Spec.Domain.Devices.Disks
+	for i := range devices {
+		if serials[devices[i].Name] {
+			devices[i].Serial = devices[i].Name
+		}
+	}
+}
+
+// validateDisks checks that the storage classes and backing PVCs the extra
+// disks of a pool refer to exist.
+func (h *HarvesterProvider) validateDisks(ctx context.Context, disks []config.DiskSpec) error {
+	for _, disk := range disks {
+		if disk.StorageClass != "" {
+			if _, err := h.StorageClassClient.StorageClasses().Get(ctx, disk.StorageClass, v1.GetOptions{}); err != nil {
+				return fmt.Errorf("invalid storage class %s of disk %s: %w: %w", disk.StorageClass, disk.Name, garmErrors.ErrBadRequest, err)
+			}
+		}
+		if disk.ClaimName != "" {
+			if _, err := h.KubeClient.CoreV1().PersistentVolumeClaims(h.GarmConfig.Namespace).Get(ctx, disk.ClaimName, v1.GetOptions{}); err != nil {
+				return fmt.Errorf("invalid claim %s of disk %s: %w: %w", disk.ClaimName, disk.Name, garmErrors.ErrBadRequest, err)
+			}
+		}
+	}
+	return nil
+}
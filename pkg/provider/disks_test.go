@@ -0,0 +1,42 @@
+package provider
+
+import (
+	"garm-provider-harvester/pkg/config"
+	"testing"
+
+	"github.com/harvester/harvester/pkg/builder"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestAddExtraDisks(t *testing.T) {
+	keep := false
+	disks := []config.DiskSpec{
+		{Name: "data", Size: "100Gi", StorageClass: "longhorn-ssd", AutoDelete: &keep},
+		{Name: "scratch", Type: config.DiskTypeEmptyDisk, Size: "50Gi", Bus: builder.DiskBusSata, MountPath: "/mnt/scratch"},
+		{Name: "cache", Type: config.DiskTypeEphemeral, ClaimName: "go-mod-cache"},
+	}
+	h := &HarvesterProvider{ControllerID: "controller"}
+	vm, err := h.addExtraDisks(builder.NewVMBuilder("garm-provider").Name("garm-runner"), disks).VM()
+	require.NoError(t, err)
+	setDiskSerials(vm, disks)
+
+	devices := vm.Spec.Template.Spec.Domain.Devices.Disks
+	require.Len(t, devices, 3)
+	for _, device := range devices {
+		require.Equal(t, device.Name, device.Serial)
+	}
+	require.Equal(t, builder.DiskBusSata, string(devices[1].Disk.Bus))
+
+	volumes := vm.Spec.Template.Spec.Volumes
+	require.NotNil(t, volumes[0].PersistentVolumeClaim)
+	require.Equal(t, resource.MustParse("50Gi"), volumes[1].EmptyDisk.Capacity)
+	require.Equal(t, "go-mod-cache", volumes[2].Ephemeral.PersistentVolumeClaim.ClaimName)
+
+	policies, err := templateAutoDelete(vm)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{volumes[0].PersistentVolumeClaim.ClaimName: false}, policies)
+
+	require.Equal(t, "/dev/disk/by-id/ata-QEMU_HARDDISK_scratch", diskMounts(disks)[0].Device)
+	require.Len(t, diskMounts(disks), 1)
+}
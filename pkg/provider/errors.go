@@ -0,0 +1,34 @@
+package provider
+
+import (
+	"fmt"
+
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// apiError wraps a Kubernetes API error together with the garm-provider-common
+// error matching its reason, so that ResolveErrorToExitCode and GARM can tell a
+// missing runner from a failed call. Errors without a matching type are only
+// annotated with the message.
+func apiError(err error, format string, a ...any) error {
+	msg := fmt.Sprintf(format, a...)
+	var garmErr error
+	switch {
+	case apierrors.IsNotFound(err):
+		garmErr = garmErrors.ErrNotFound
+	case apierrors.IsAlreadyExists(err):
+		garmErr = garmErrors.ErrDuplicateEntity
+	case apierrors.IsConflict(err):
+		garmErr = garmErrors.NewConflictError("conflict")
+	case apierrors.IsForbidden(err), apierrors.IsUnauthorized(err):
+		garmErr = garmErrors.ErrUnauthorized
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		garmErr = garmErrors.ErrBadRequest
+	case apierrors.IsTimeout(err), apierrors.IsServerTimeout(err):
+		garmErr = garmErrors.ErrTimeout
+	default:
+		return fmt.Errorf("%s: %w", msg, err)
+	}
+	return fmt.Errorf("%s: %w: %w", msg, garmErr, err)
+}
@@ -0,0 +1,60 @@
+package provider
+
+import (
+	"errors"
+	"testing"
+
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
+	commonExecution "github.com/cloudbase/garm-provider-common/execution/common"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	kubeschema "k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestAPIError(t *testing.T) {
+	vmResource := kubeschema.GroupResource{Group: "kubevirt.io", Resource: "virtualmachines"}
+	tests := []struct {
+		name     string
+		err      error
+		target   error
+		exitCode int
+	}{
+		{
+			name:     "not found",
+			err:      apierrors.NewNotFound(vmResource, "garm-runner"),
+			target:   garmErrors.ErrNotFound,
+			exitCode: commonExecution.ExitCodeNotFound,
+		},
+		{
+			name:     "already exists",
+			err:      apierrors.NewAlreadyExists(vmResource, "garm-runner"),
+			target:   garmErrors.ErrDuplicateEntity,
+			exitCode: commonExecution.ExitCodeDuplicate,
+		},
+		{
+			name:     "forbidden",
+			err:      apierrors.NewForbidden(vmResource, "garm-runner", errors.New("denied")),
+			target:   garmErrors.ErrUnauthorized,
+			exitCode: 1,
+		},
+		{
+			name:     "invalid",
+			err:      apierrors.NewBadRequest("bad spec"),
+			target:   garmErrors.ErrBadRequest,
+			exitCode: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := apiError(tt.err, "failed to get instance %s", "garm-runner")
+			require.ErrorIs(t, err, tt.target)
+			require.ErrorIs(t, err, tt.err)
+			require.Equal(t, tt.exitCode, commonExecution.ResolveErrorToExitCode(err))
+		})
+	}
+
+	conflict := apiError(apierrors.NewConflict(vmResource, "garm-runner", errors.New("modified")), "failed to update instance %s", "garm-runner")
+	var conflictErr *garmErrors.ConflictError
+	require.ErrorAs(t, conflict, &conflictErr)
+}
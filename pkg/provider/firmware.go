@@ -0,0 +1,67 @@
+package provider
+
+import (
+	"garm-provider-harvester/pkg/config"
+
+	"k8s.io/utils/ptr"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+// hyperVSpinlockRetries is the spinlock retry count Windows guests are given,
+// as in the KubeVirt Windows templates.
+const hyperVSpinlockRetries = 8191
+
+// applyFirmware sets the firmware of a runner VM. KubeVirt enables Secure Boot
+// on EFI unless told otherwise, and Secure Boot needs SMM.
+func applyFirmware(spec *kubevirtv1.VirtualMachineInstanceSpec, firmware string) {
+	if firmware == config.FirmwareBIOS || firmware == "" {
+		return
+	}
+	secureBoot := firmware == config.FirmwareSecureBoot
+	if spec.Domain.Firmware == nil {
+		spec.Domain.Firmware = &kubevirtv1.Firmware{}
+	}
+	spec.Domain.Firmware.Bootloader = &kubevirtv1.Bootloader{
+		EFI: &kubevirtv1.EFI{SecureBoot: ptr.To(secureBoot)},
+	}
+	if secureBoot {
+		if spec.Domain.Features == nil {
+			spec.Domain.Features = &kubevirtv1.Features{}
+		}
+		spec.Domain.Features.SMM = &kubevirtv1.FeatureState{Enabled: ptr.To(true)}
+	}
+}
+
+// applyHyperV enables the Hyper-V enlightenments and the clock Windows guests
+// need to run well and keep time on KVM. Enlightenments that tie a VM to the
+// TSC frequency of its node are left out so runners can still be migrated.
+func applyHyperV(spec *kubevirtv1.VirtualMachineInstanceSpec) {
+	enabled := &kubevirtv1.FeatureState{Enabled: ptr.To(true)}
+	if spec.Domain.Features == nil {
+		spec.Domain.Features = &kubevirtv1.Features{}
+	}
+	spec.Domain.Features.ACPI = kubevirtv1.FeatureState{Enabled: ptr.To(true)}
+	spec.Domain.Features.APIC = &kubevirtv1.FeatureAPIC{Enabled: ptr.To(true)}
+	spec.Domain.Features.Hyperv = &kubevirtv1.FeatureHyperv{
+		Relaxed:    enabled,
+		VAPIC:      enabled,
+		Spinlocks:  &kubevirtv1.FeatureSpinlocks{Enabled: ptr.To(true), Retries: ptr.To(uint32(hyperVSpinlockRetries))},
+		VPIndex:    enabled,
+		Runtime:    enabled,
+		SyNIC:      enabled,
+		SyNICTimer: &kubevirtv1.SyNICTimer{Enabled: ptr.To(true), Direct: enabled},
+		Reset:      enabled,
+		TLBFlush:   enabled,
+		IPI:        enabled,
+	}
+
+	spec.Domain.Clock = &kubevirtv1.Clock{
+		ClockOffset: kubevirtv1.ClockOffset{UTC: &kubevirtv1.ClockOffsetUTC{}},
+		Timer: &kubevirtv1.Timer{
+			HPET:   &kubevirtv1.HPETTimer{Enabled: ptr.To(false)},
+			PIT:    &kubevirtv1.PITTimer{TickPolicy: kubevirtv1.PITTickPolicyDelay},
+			RTC:    &kubevirtv1.RTCTimer{TickPolicy: kubevirtv1.RTCTickPolicyCatchup},
+			Hyperv: &kubevirtv1.HypervTimer{},
+		},
+	}
+}
@@ -0,0 +1,37 @@
+package provider
+
+import (
+	"garm-provider-harvester/pkg/config"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+func TestApplyFirmware(t *testing.T) {
+	spec := &kubevirtv1.VirtualMachineInstanceSpec{}
+	applyFirmware(spec, config.FirmwareBIOS)
+	require.Nil(t, spec.Domain.Firmware)
+
+	applyFirmware(spec, config.FirmwareEFI)
+	require.False(t, *spec.Domain.Firmware.Bootloader.EFI.SecureBoot)
+	require.Nil(t, spec.Domain.Features)
+
+	spec = &kubevirtv1.VirtualMachineInstanceSpec{}
+	applyFirmware(spec, config.FirmwareSecureBoot)
+	require.True(t, *spec.Domain.Firmware.Bootloader.EFI.SecureBoot)
+	require.True(t, *spec.Domain.Features.SMM.Enabled)
+}
+
+func TestApplyHyperV(t *testing.T) {
+	spec := &kubevirtv1.VirtualMachineInstanceSpec{}
+	applyFirmware(spec, config.FirmwareSecureBoot)
+	applyHyperV(spec)
+
+	require.True(t, *spec.Domain.Features.SMM.Enabled)
+	require.True(t, *spec.Domain.Features.Hyperv.Relaxed.Enabled)
+	require.Equal(t, uint32(hyperVSpinlockRetries), *spec.Domain.Features.Hyperv.Spinlocks.Retries)
+	require.NotNil(t, spec.Domain.Clock.UTC)
+	require.False(t, *spec.Domain.Clock.Timer.HPET.Enabled)
+	require.NotNil(t, spec.Domain.Clock.Timer.Hyperv)
+}
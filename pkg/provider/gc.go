@@ -0,0 +1,237 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"garm-provider-harvester/pkg/utils"
+	"log/slog"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+const (
+	autoDeleteAnnotation  = "terraform-provider-harvester-auto-delete"
+	cloudInitSecretSuffix = "-cloudinit"
+
+	// DefaultGCMaxAge is how old a leftover has to be before the garbage
+	// collector removes it.
+	DefaultGCMaxAge = time.Hour
+)
+
+// GCOptions configures CollectGarbage.
+type GCOptions struct {
+	// DryRun reports what would be removed without removing anything.
+	DryRun bool
+	// MaxAge is how old a resource has to be to be collected. It keeps the
+	// collector away from resources of creates still in flight.
+	MaxAge time.Duration
+}
+
+// GCResource is a resource found by the garbage collector.
+type GCResource struct {
+	Kind    string    `json:"kind"`
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Reason  string    `json:"reason"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// GCReport lists what the garbage collector found and what it removed.
+type GCReport struct {
+	DryRun     bool         `json:"dry_run"`
+	Candidates []GCResource `json:"candidates"`
+	Deleted    []GCResource `json:"deleted"`
+	Failed     []GCResource `json:"failed"`
+	// Skipped are VMs the collector could not check, nothing was done to them.
+	Skipped []GCResource `json:"skipped"`
+}
+
+// CollectGarbage removes what failed creates and interrupted deletes leave
+// behind for this controller: boot PVCs and cloud-init secrets whose VM no
+// longer exists, and VMs whose guest never started, see neverStarted. Only
+// resources older than MaxAge are collected.
+//
+// PVCs and secrets are listed before VMs. Both are created after their VM, so
+// a PVC or secret seen without a VM really lost it rather than racing a create.
+func (h *HarvesterProvider) CollectGarbage(ctx context.Context, opts GCOptions) (*GCReport, error) {
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = DefaultGCMaxAge
+	}
+	controllerLabel := fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, controllerIdConst)
+	selector := labels.SelectorFromSet(labels.Set{controllerLabel: h.ControllerID})
+
+	pvcs, err := h.KubeClient.CoreV1().PersistentVolumeClaims(h.GarmConfig.Namespace).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return nil, apiError(err, "failed to list pvcs in NS %s", h.GarmConfig.Namespace)
+	}
+	secrets, err := h.KubeClient.CoreV1().Secrets(h.GarmConfig.Namespace).List(ctx, v1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, apiError(err, "failed to list secrets in NS %s", h.GarmConfig.Namespace)
+	}
+	vms, err := h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return nil, apiError(err, "failed to get VM list for NS %s", h.GarmConfig.Namespace)
+	}
+
+	existingVMs := make(map[string]bool, len(vms.Items))
+	claimedPVCs := make(map[string]bool)
+	for _, vm := range vms.Items {
+		existingVMs[vm.Name] = true
+		for _, volume := range vm.Spec.Template.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				claimedPVCs[volume.PersistentVolumeClaim.ClaimName] = true
+			}
+		}
+	}
+
+	report := &GCReport{DryRun: opts.DryRun, Candidates: []GCResource{}, Deleted: []GCResource{}, Failed: []GCResource{}, Skipped: []GCResource{}}
+	var deletes []func() error
+	var errs []error
+
+	for _, pvc := range pvcs.Items {
+		if pvc.DeletionTimestamp != nil || pvc.Annotations[autoDeleteAnnotation] != "true" ||
+			pvc.Annotations[controllerLabel] != h.ControllerID || claimedPVCs[pvc.Name] ||
+			time.Since(pvc.CreationTimestamp.Time) < opts.MaxAge {
+			continue
+		}
+		found := GCResource{Kind: "PersistentVolumeClaim", Name: pvc.Name, Created: pvc.CreationTimestamp.Time, Reason: "no VM uses this volume"}
+		report.Candidates = append(report.Candidates, found)
+		uid := string(pvc.UID)
+		deletes = append(deletes, func() error {
+			return report.record(found, h.KubeClient.CoreV1().PersistentVolumeClaims(h.GarmConfig.Namespace).Delete(ctx, found.Name, v1.DeleteOptions{Preconditions: v1.NewUIDPreconditions(uid)}))
+		})
+	}
+
+	for _, secret := range secrets.Items {
+		if secret.DeletionTimestamp != nil || !strings.HasSuffix(secret.Name, cloudInitSecretSuffix) ||
+			time.Since(secret.CreationTimestamp.Time) < opts.MaxAge {
+			continue
+		}
+		owner := strings.TrimSuffix(secret.Name, cloudInitSecretSuffix)
+		for _, ref := range secret.OwnerReferences {
+			owner = ref.Name
+		}
+		if existingVMs[owner] {
+			continue
+		}
+		found := GCResource{Kind: "Secret", Name: secret.Name, Created: secret.CreationTimestamp.Time, Reason: fmt.Sprintf("VM %s no longer exists", owner)}
+		report.Candidates = append(report.Candidates, found)
+		uid := string(secret.UID)
+		deletes = append(deletes, func() error {
+			return report.record(found, h.KubeClient.CoreV1().Secrets(h.GarmConfig.Namespace).Delete(ctx, found.Name, v1.DeleteOptions{Preconditions: v1.NewUIDPreconditions(uid)}))
+		})
+	}
+
+	for i := range vms.Items {
+		vm := &vms.Items[i]
+		if vm.Labels[controllerLabel] != h.ControllerID || vm.DeletionTimestamp != nil ||
+			time.Since(vm.CreationTimestamp.Time) < opts.MaxAge {
+			continue
+		}
+		neverStarted, reason, err := h.neverStarted(ctx, vm, opts.MaxAge)
+		if err != nil {
+			report.Skipped = append(report.Skipped, GCResource{Kind: "VirtualMachine", Name: vm.Name, Created: vm.CreationTimestamp.Time, Reason: "could not check whether it started", Error: err.Error()})
+			errs = append(errs, err)
+			continue
+		}
+		if !neverStarted {
+			continue
+		}
+		found := GCResource{Kind: "VirtualMachine", Name: vm.Name, Created: vm.CreationTimestamp.Time, Reason: reason}
+		report.Candidates = append(report.Candidates, found)
+		deletes = append(deletes, func() error {
+			_, err := h.deleteVM(ctx, vm)
+			return report.record(found, err)
+		})
+	}
+
+	if opts.DryRun {
+		return report, errors.Join(errs...)
+	}
+	for _, del := range deletes {
+		if err := del(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// startFailureStatuses are the printable statuses of a VM that KubeVirt cannot
+// get to run: its VMI cannot be scheduled, its launcher image cannot be pulled
+// or its volumes never came up.
+var startFailureStatuses = map[kubevirtv1.VirtualMachinePrintableStatus]bool{
+	kubevirtv1.VirtualMachineStatusProvisioning:            true,
+	kubevirtv1.VirtualMachineStatusWaitingForVolumeBinding: true,
+	kubevirtv1.VirtualMachineStatusUnschedulable:           true,
+	kubevirtv1.VirtualMachineStatusErrImagePull:            true,
+	kubevirtv1.VirtualMachineStatusImagePullBackOff:        true,
+	kubevirtv1.VirtualMachineStatusPvcNotFound:             true,
+	kubevirtv1.VirtualMachineStatusDataVolumeError:         true,
+	kubevirtv1.VirtualMachineStatusCrashLoopBackOff:        true,
+}
+
+// neverStarted reports whether a VM is stuck before its guest ever ran, and
+// why. A missing VMI alone proves nothing, it is briefly gone whenever a
+// runner restarts, so the decision rests on what KubeVirt reports:
+//
+//   - a VM stopped on purpose, ready, or whose VMI runs or ever ran is kept;
+//   - a VMI still pending or scheduling after maxAge never started;
+//   - without a VMI, the VM has to report a start failure.
+func (h *HarvesterProvider) neverStarted(ctx context.Context, vm *kubevirtv1.VirtualMachine, maxAge time.Duration) (bool, string, error) {
+	runStrategy, err := vm.RunStrategy()
+	if err != nil || runStrategy == kubevirtv1.RunStrategyHalted || vm.Status.Ready {
+		return false, "", nil
+	}
+	vmi, err := h.getVMI(ctx, vm.Name)
+	if err != nil {
+		return false, "", apiError(err, "failed to get VMI of instance %s", vm.Name)
+	}
+
+	if vmi != nil {
+		if vmi.DeletionTimestamp != nil || time.Since(vmi.CreationTimestamp.Time) < maxAge {
+			return false, "", nil
+		}
+		for _, transition := range vmi.Status.PhaseTransitionTimestamps {
+			if transition.Phase == kubevirtv1.Running {
+				return false, "", nil
+			}
+		}
+		switch phase := vmi.Status.Phase; phase {
+		case kubevirtv1.VmPhaseUnset, kubevirtv1.Pending, kubevirtv1.Scheduling, kubevirtv1.Scheduled:
+			if phase == kubevirtv1.VmPhaseUnset {
+				phase = kubevirtv1.Pending
+			}
+			return true, fmt.Sprintf("VMI stuck in %s for over %s (%s)", phase, maxAge, vm.Status.PrintableStatus), nil
+		}
+		return false, "", nil
+	}
+
+	if vm.Status.Created {
+		return false, "", nil
+	}
+	if vm.Status.StartFailure != nil {
+		return true, fmt.Sprintf("failed to start %d times (%s)", vm.Status.StartFailure.ConsecutiveFailCount, vm.Status.PrintableStatus), nil
+	}
+	if startFailureStatuses[vm.Status.PrintableStatus] {
+		return true, fmt.Sprintf("never started (%s)", vm.Status.PrintableStatus), nil
+	}
+	return false, "", nil
+}
+
+// record adds the outcome of removing found to the report.
+func (r *GCReport) record(found GCResource, err error) error {
+	if err != nil && !apierrors.IsNotFound(err) {
+		found.Error = err.Error()
+		r.Failed = append(r.Failed, found)
+		return fmt.Errorf("failed to remove %s %s: %w", strings.ToLower(found.Kind), found.Name, err)
+	}
+	slog.Info(fmt.Sprintf("garbage collected %s %s: %s", strings.ToLower(found.Kind), found.Name, found.Reason))
+	r.Deleted = append(r.Deleted, found)
+	return nil
+}
@@ -0,0 +1,228 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"garm-provider-harvester/pkg/config"
+	"garm-provider-harvester/pkg/utils"
+	"testing"
+	"time"
+
+	harvfake "github.com/harvester/harvester/pkg/generated/clientset/versioned/fake"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeschema "k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+func TestGCReportRecord(t *testing.T) {
+	report := &GCReport{}
+	secret := GCResource{Kind: "Secret", Name: "garm-runner-cloudinit"}
+	pvc := GCResource{Kind: "PersistentVolumeClaim", Name: "garm-runner-rootdisk-abcde"}
+	vm := GCResource{Kind: "VirtualMachine", Name: "garm-runner"}
+
+	require.NoError(t, report.record(secret, nil))
+	require.NoError(t, report.record(pvc, apierrors.NewNotFound(kubeschema.GroupResource{Resource: "persistentvolumeclaims"}, pvc.Name)))
+	err := report.record(vm, errors.New("boom"))
+	require.EqualError(t, err, "failed to remove virtualmachine garm-runner: boom")
+
+	require.Equal(t, []GCResource{secret, pvc}, report.Deleted)
+	require.Len(t, report.Failed, 1)
+	require.Equal(t, "boom", report.Failed[0].Error)
+}
+
+const (
+	gcNamespace  = "garm"
+	gcController = "controller"
+)
+
+func gcMeta(name string, created time.Time) v1.ObjectMeta {
+	return v1.ObjectMeta{
+		Name:              name,
+		Namespace:         gcNamespace,
+		UID:               types.UID(name),
+		CreationTimestamp: v1.NewTime(created),
+		Labels:            map[string]string{fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, controllerIdConst): gcController},
+	}
+}
+
+func gcVM(name string, created time.Time, runStrategy kubevirtv1.VirtualMachineRunStrategy, claims ...string) *kubevirtv1.VirtualMachine {
+	vm := &kubevirtv1.VirtualMachine{ObjectMeta: gcMeta(name, created)}
+	vm.Spec.RunStrategy = &runStrategy
+	vm.Spec.Template = &kubevirtv1.VirtualMachineInstanceTemplateSpec{}
+	for _, claim := range claims {
+		vm.Spec.Template.Spec.Volumes = append(vm.Spec.Template.Spec.Volumes, kubevirtv1.Volume{
+			Name: claim,
+			VolumeSource: kubevirtv1.VolumeSource{
+				PersistentVolumeClaim: &kubevirtv1.PersistentVolumeClaimVolumeSource{
+					PersistentVolumeClaimVolumeSource: corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+				},
+			},
+		})
+	}
+	return vm
+}
+
+func gcPVC(name string, created time.Time) *corev1.PersistentVolumeClaim {
+	meta := gcMeta(name, created)
+	meta.Labels = nil
+	meta.Annotations = map[string]string{
+		autoDeleteAnnotation: "true",
+		fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, controllerIdConst): gcController,
+	}
+	return &corev1.PersistentVolumeClaim{ObjectMeta: meta}
+}
+
+func gcSecret(name string, created time.Time, owner string) *corev1.Secret {
+	secret := &corev1.Secret{ObjectMeta: gcMeta(name, created)}
+	if owner != "" {
+		secret.OwnerReferences = []v1.OwnerReference{{Kind: "VirtualMachine", Name: owner, UID: types.UID(owner)}}
+	}
+	return secret
+}
+
+// newGCProvider returns a provider on fake clients holding the leftovers of
+// a controller, next to resources the garbage collector has to keep.
+func newGCProvider() *HarvesterProvider {
+	old := time.Now().Add(-2 * DefaultGCMaxAge)
+	young := time.Now()
+
+	running := gcVM("running", old, kubevirtv1.RunStrategyRerunOnFailure)
+	running.Status = kubevirtv1.VirtualMachineStatus{Created: true, Ready: true, PrintableStatus: kubevirtv1.VirtualMachineStatusRunning}
+	restarting := gcVM("restarting", old, kubevirtv1.RunStrategyRerunOnFailure)
+	restarting.Status = kubevirtv1.VirtualMachineStatus{PrintableStatus: kubevirtv1.VirtualMachineStatusStarting}
+	unschedulable := gcVM("unschedulable", old, kubevirtv1.RunStrategyRerunOnFailure)
+	unschedulable.Status = kubevirtv1.VirtualMachineStatus{PrintableStatus: kubevirtv1.VirtualMachineStatusUnschedulable}
+	crashing := gcVM("crashing", old, kubevirtv1.RunStrategyRerunOnFailure)
+	crashing.Status = kubevirtv1.VirtualMachineStatus{
+		PrintableStatus: kubevirtv1.VirtualMachineStatusCrashLoopBackOff,
+		StartFailure:    &kubevirtv1.VirtualMachineStartFailure{ConsecutiveFailCount: 3},
+	}
+	pending := gcVM("pending", old, kubevirtv1.RunStrategyRerunOnFailure)
+	pending.Status = kubevirtv1.VirtualMachineStatus{Created: true, PrintableStatus: kubevirtv1.VirtualMachineStatusUnschedulable}
+	rebooted := gcVM("rebooted", old, kubevirtv1.RunStrategyRerunOnFailure)
+	rebooted.Status = kubevirtv1.VirtualMachineStatus{Created: true, PrintableStatus: kubevirtv1.VirtualMachineStatusStarting}
+
+	vms := []*kubevirtv1.VirtualMachine{
+		gcVM("stopped", old, kubevirtv1.RunStrategyHalted, "stopped-rootdisk"),
+		gcVM("starting", young, kubevirtv1.RunStrategyRerunOnFailure),
+		running, restarting, unschedulable, crashing, pending, rebooted,
+	}
+	runningVMI := &kubevirtv1.VirtualMachineInstance{ObjectMeta: gcMeta("running", old)}
+	runningVMI.Status.Phase = kubevirtv1.Running
+	pendingVMI := &kubevirtv1.VirtualMachineInstance{ObjectMeta: gcMeta("pending", old)}
+	pendingVMI.Status.Phase = kubevirtv1.Pending
+	// The VMI of a VM that ran before, recreated after a reboot and waiting to
+	// be scheduled again.
+	rebootedVMI := &kubevirtv1.VirtualMachineInstance{ObjectMeta: gcMeta("rebooted", old)}
+	rebootedVMI.Status.Phase = kubevirtv1.Scheduling
+	rebootedVMI.Status.PhaseTransitionTimestamps = []kubevirtv1.VirtualMachineInstancePhaseTransitionTimestamp{
+		{Phase: kubevirtv1.Running, PhaseTransitionTimestamp: v1.NewTime(old)},
+	}
+
+	harvesterClient := harvfake.NewSimpleClientset(runningVMI, pendingVMI, rebootedVMI)
+	for _, vm := range vms {
+		_ = harvesterClient.Tracker().Add(vm)
+	}
+	kubeClient := k8sfake.NewSimpleClientset(
+		gcPVC("stopped-rootdisk", old),
+		gcPVC("orphan-rootdisk", old),
+		gcPVC("creating-rootdisk", young),
+		gcSecret("stopped-cloudinit", old, "stopped"),
+		gcSecret("gone-cloudinit", old, "gone"),
+		gcSecret("creating-cloudinit", young, ""),
+	)
+
+	return &HarvesterProvider{
+		GarmConfig:      &config.Config{Namespace: gcNamespace},
+		KubeClient:      kubeClient,
+		HarvesterClient: harvesterClient,
+		ControllerID:    gcController,
+	}
+}
+
+func gcNames(resources []GCResource) []string {
+	names := make([]string, 0, len(resources))
+	for _, resource := range resources {
+		names = append(names, fmt.Sprintf("%s/%s", resource.Kind, resource.Name))
+	}
+	return names
+}
+
+func gcExisting(t *testing.T, h *HarvesterProvider) []string {
+	var names []string
+	pvcs, err := h.KubeClient.CoreV1().PersistentVolumeClaims(gcNamespace).List(t.Context(), v1.ListOptions{})
+	require.NoError(t, err)
+	for _, pvc := range pvcs.Items {
+		names = append(names, "PersistentVolumeClaim/"+pvc.Name)
+	}
+	secrets, err := h.KubeClient.CoreV1().Secrets(gcNamespace).List(t.Context(), v1.ListOptions{})
+	require.NoError(t, err)
+	for _, secret := range secrets.Items {
+		names = append(names, "Secret/"+secret.Name)
+	}
+	vms, err := h.HarvesterClient.KubevirtV1().VirtualMachines(gcNamespace).List(t.Context(), v1.ListOptions{})
+	require.NoError(t, err)
+	for _, vm := range vms.Items {
+		names = append(names, "VirtualMachine/"+vm.Name)
+	}
+	return names
+}
+
+func TestCollectGarbage(t *testing.T) {
+	leftovers := []string{
+		"PersistentVolumeClaim/orphan-rootdisk", "Secret/gone-cloudinit",
+		"VirtualMachine/crashing", "VirtualMachine/pending", "VirtualMachine/unschedulable",
+	}
+	kept := []string{
+		"PersistentVolumeClaim/creating-rootdisk", "PersistentVolumeClaim/stopped-rootdisk",
+		"Secret/creating-cloudinit", "Secret/stopped-cloudinit",
+		"VirtualMachine/rebooted", "VirtualMachine/restarting", "VirtualMachine/running",
+		"VirtualMachine/starting", "VirtualMachine/stopped",
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		h := newGCProvider()
+		report, err := h.CollectGarbage(t.Context(), GCOptions{DryRun: true})
+		require.NoError(t, err)
+		require.True(t, report.DryRun)
+		require.Equal(t, leftovers, gcNames(report.Candidates))
+		require.Equal(t, "failed to start 3 times (CrashLoopBackOff)", report.Candidates[2].Reason)
+		require.Equal(t, "VMI stuck in Pending for over 1h0m0s (ErrorUnschedulable)", report.Candidates[3].Reason)
+		require.Empty(t, report.Deleted)
+		require.ElementsMatch(t, append(kept, leftovers...), gcExisting(t, h))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		h := newGCProvider()
+		report, err := h.CollectGarbage(t.Context(), GCOptions{})
+		require.NoError(t, err)
+		require.Equal(t, leftovers, gcNames(report.Candidates))
+		require.Equal(t, leftovers, gcNames(report.Deleted))
+		require.Empty(t, report.Failed)
+		require.ElementsMatch(t, kept, gcExisting(t, h))
+	})
+
+	t.Run("failed start check", func(t *testing.T) {
+		h := newGCProvider()
+		h.HarvesterClient.(*harvfake.Clientset).PrependReactor("get", "virtualmachineinstances", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("boom")
+		})
+		report, err := h.CollectGarbage(t.Context(), GCOptions{})
+		require.ErrorContains(t, err, "failed to get VMI of instance crashing: boom")
+		require.NotNil(t, report)
+		require.Empty(t, report.Failed)
+		require.Equal(t, []string{"PersistentVolumeClaim/orphan-rootdisk", "Secret/gone-cloudinit"}, gcNames(report.Deleted))
+		require.Equal(t, []string{
+			"VirtualMachine/crashing", "VirtualMachine/pending", "VirtualMachine/rebooted",
+			"VirtualMachine/restarting", "VirtualMachine/unschedulable",
+		}, gcNames(report.Skipped))
+		require.Contains(t, gcExisting(t, h), "VirtualMachine/crashing")
+	})
+}
@@ -0,0 +1,118 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"garm-provider-harvester/pkg/utils"
+	"log/slog"
+	"strings"
+
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
+	corev1 "k8s.io/api/core/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+// The operations below are not part of the GARM external provider interface.
+// They let operators unstick a hung runner or freeze one for inspection without
+// deleting it.
+
+// Restart reboots a running runner VM.
+func (h *HarvesterProvider) Restart(ctx context.Context, instance string) error {
+	vm, err := h.getOwnedVM(ctx, instance)
+	if err != nil {
+		return err
+	}
+	if err := h.vmSubresource(ctx, vm.Name, "restart", &kubevirtv1.RestartOptions{}); err != nil {
+		return apiError(err, "failed to restart instance %s", vm.Name)
+	}
+	slog.Info(fmt.Sprintf("%s: restarted", vm.Name))
+	return nil
+}
+
+// Shutdown stops a runner VM, giving its guest the configured grace period to
+// shut down unless force is set.
+func (h *HarvesterProvider) Shutdown(ctx context.Context, instance string, force bool) error {
+	vm, err := h.getOwnedVM(ctx, instance)
+	if err != nil {
+		return err
+	}
+	if err := h.Stop(ctx, vm.Name, force); err != nil {
+		return err
+	}
+	slog.Info(fmt.Sprintf("%s: stopped", vm.Name))
+	return nil
+}
+
+// Pause freezes the guest of a running runner VM. Pausing a paused VM is a no-op.
+func (h *HarvesterProvider) Pause(ctx context.Context, instance string) error {
+	vm, err := h.getOwnedVM(ctx, instance)
+	if err != nil {
+		return err
+	}
+	vmi, err := h.getVMI(ctx, vm.Name)
+	if err != nil {
+		return apiError(err, "failed to get VMI of instance %s", vm.Name)
+	}
+	if vmi == nil || !vmi.IsRunning() {
+		return fmt.Errorf("instance %s is not running: %w", vm.Name, garmErrors.ErrBadRequest)
+	}
+	if isPaused(vmi) {
+		return nil
+	}
+	if err := h.vmiSubresource(ctx, vm.Name, "pause", &kubevirtv1.PauseOptions{}); err != nil {
+		return apiError(err, "failed to pause instance %s", vm.Name)
+	}
+	slog.Info(fmt.Sprintf("%s: paused", vm.Name))
+	return nil
+}
+
+// Unpause resumes the guest of a paused runner VM. Resuming a VM that is not
+// paused is a no-op.
+func (h *HarvesterProvider) Unpause(ctx context.Context, instance string) error {
+	vm, err := h.getOwnedVM(ctx, instance)
+	if err != nil {
+		return err
+	}
+	vmi, err := h.getVMI(ctx, vm.Name)
+	if err != nil {
+		return apiError(err, "failed to get VMI of instance %s", vm.Name)
+	}
+	if vmi == nil || !isPaused(vmi) {
+		return nil
+	}
+	if err := h.vmiSubresource(ctx, vm.Name, "unpause", &kubevirtv1.UnpauseOptions{}); err != nil {
+		return apiError(err, "failed to unpause instance %s", vm.Name)
+	}
+	slog.Info(fmt.Sprintf("%s: unpaused", vm.Name))
+	return nil
+}
+
+// getOwnedVM looks up a runner VM and checks that it belongs to this controller.
+func (h *HarvesterProvider) getOwnedVM(ctx context.Context, instance string) (*kubevirtv1.VirtualMachine, error) {
+	vm, err := h.getVM(ctx, instance)
+	if err != nil {
+		return nil, apiError(err, "failed to get instance %s", strings.ToLower(instance))
+	}
+	if err := h.checkOwner(vm); err != nil {
+		return nil, err
+	}
+	return vm, nil
+}
+
+// checkOwner returns an error if a VM was not created by this controller.
+func (h *HarvesterProvider) checkOwner(vm *kubevirtv1.VirtualMachine) error {
+	val, ok := vm.Labels[fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, controllerIdConst)]
+	if !ok || val != h.ControllerID {
+		return fmt.Errorf("found instance %s but doesn't have label %s/%s=%s: %w", vm.Name, utils.HarvesterAPIGroup, controllerIdConst, h.ControllerID, garmErrors.ErrUnauthorized)
+	}
+	return nil
+}
+
+func isPaused(vmi *kubevirtv1.VirtualMachineInstance) bool {
+	for _, cond := range vmi.Status.Conditions {
+		if cond.Type == kubevirtv1.VirtualMachineInstancePaused {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"garm-provider-harvester/pkg/config"
+	"garm-provider-harvester/pkg/utils"
+	"net/http"
+	"strings"
+	"testing"
+
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
+	harvfake "github.com/harvester/harvester/pkg/generated/clientset/versioned/fake"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+func operationsVM(controller string) *kubevirtv1.VirtualMachine {
+	return &kubevirtv1.VirtualMachine{ObjectMeta: v1.ObjectMeta{
+		Name:      "garm-runner",
+		Namespace: "garm",
+		Labels:    map[string]string{fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, controllerIdConst): controller},
+	}}
+}
+
+func operationsVMI(phase kubevirtv1.VirtualMachineInstancePhase, paused bool) *kubevirtv1.VirtualMachineInstance {
+	vmi := &kubevirtv1.VirtualMachineInstance{ObjectMeta: v1.ObjectMeta{Name: "garm-runner", Namespace: "garm"}}
+	vmi.Status.Phase = phase
+	if paused {
+		vmi.Status.Conditions = []kubevirtv1.VirtualMachineInstanceCondition{
+			{Type: kubevirtv1.VirtualMachineInstancePaused, Status: corev1.ConditionTrue},
+		}
+	}
+	return vmi
+}
+
+func TestOperations(t *testing.T) {
+	restart := (*HarvesterProvider).Restart
+	pause := (*HarvesterProvider).Pause
+	unpause := (*HarvesterProvider).Unpause
+	forceStop := func(h *HarvesterProvider, ctx context.Context, instance string) error {
+		return h.Shutdown(ctx, instance, true)
+	}
+
+	tests := []struct {
+		name      string
+		operation func(*HarvesterProvider, context.Context, string) error
+		objects   []runtime.Object
+		// status is what the subresource API answers, 200 when unset.
+		status   int
+		errIs    error
+		errMsg   string
+		requests []string
+	}{
+		{
+			name:      "restart",
+			operation: restart,
+			objects:   []runtime.Object{operationsVM("controller"), operationsVMI(kubevirtv1.Running, false)},
+			requests:  []string{"virtualmachines/garm-runner/restart"},
+		},
+		{
+			name:      "restart other controller",
+			operation: restart,
+			objects:   []runtime.Object{operationsVM("other")},
+			errIs:     garmErrors.ErrUnauthorized,
+			errMsg:    "found instance garm-runner but doesn't have label harvesterhci.io/controller-id=controller",
+		},
+		{
+			name:      "restart missing",
+			operation: restart,
+			errIs:     garmErrors.ErrNotFound,
+		},
+		{
+			name:      "restart refused",
+			operation: restart,
+			objects:   []runtime.Object{operationsVM("controller")},
+			status:    http.StatusConflict,
+			errMsg:    "failed to restart instance garm-runner",
+			requests:  []string{"virtualmachines/garm-runner/restart"},
+		},
+		{
+			name:      "pause",
+			operation: pause,
+			objects:   []runtime.Object{operationsVM("controller"), operationsVMI(kubevirtv1.Running, false)},
+			requests:  []string{"virtualmachineinstances/garm-runner/pause"},
+		},
+		{
+			name:      "pause already paused",
+			operation: pause,
+			objects:   []runtime.Object{operationsVM("controller"), operationsVMI(kubevirtv1.Running, true)},
+		},
+		{
+			name:      "pause not running",
+			operation: pause,
+			objects:   []runtime.Object{operationsVM("controller"), operationsVMI(kubevirtv1.Scheduling, false)},
+			errIs:     garmErrors.ErrBadRequest,
+			errMsg:    "instance garm-runner is not running",
+		},
+		{
+			name:      "pause stopped",
+			operation: pause,
+			objects:   []runtime.Object{operationsVM("controller")},
+			errIs:     garmErrors.ErrBadRequest,
+		},
+		{
+			name:      "pause other controller",
+			operation: pause,
+			objects:   []runtime.Object{operationsVM("other"), operationsVMI(kubevirtv1.Running, false)},
+			errIs:     garmErrors.ErrUnauthorized,
+		},
+		{
+			name:      "unpause",
+			operation: unpause,
+			objects:   []runtime.Object{operationsVM("controller"), operationsVMI(kubevirtv1.Running, true)},
+			requests:  []string{"virtualmachineinstances/garm-runner/unpause"},
+		},
+		{
+			name:      "unpause not paused",
+			operation: unpause,
+			objects:   []runtime.Object{operationsVM("controller"), operationsVMI(kubevirtv1.Running, false)},
+		},
+		{
+			name:      "unpause stopped",
+			operation: unpause,
+			objects:   []runtime.Object{operationsVM("controller")},
+		},
+		{
+			name:      "unpause other controller",
+			operation: unpause,
+			objects:   []runtime.Object{operationsVM("other"), operationsVMI(kubevirtv1.Running, true)},
+			errIs:     garmErrors.ErrUnauthorized,
+		},
+		{
+			name:      "stop",
+			operation: forceStop,
+			objects:   []runtime.Object{operationsVM("controller"), operationsVMI(kubevirtv1.Running, false)},
+			requests:  []string{"virtualmachines/garm-runner/stop"},
+		},
+		{
+			name:      "stop other controller",
+			operation: forceStop,
+			objects:   []runtime.Object{operationsVM("other"), operationsVMI(kubevirtv1.Running, false)},
+			errIs:     garmErrors.ErrUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []string
+			restClient := newSubresourceClient(t, func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, strings.TrimPrefix(r.URL.Path, "/apis/subresources.kubevirt.io/v1/namespaces/garm/"))
+				w.Header().Set("Content-Type", "application/json")
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+					_, _ = fmt.Fprintf(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","code":%d}`, tt.status)
+				}
+			})
+			h := &HarvesterProvider{
+				GarmConfig:                &config.Config{Namespace: "garm"},
+				KubeVirtSubresourceClient: restClient,
+				HarvesterClient:           harvfake.NewSimpleClientset(tt.objects...),
+				ControllerID:              "controller",
+			}
+
+			err := tt.operation(h, t.Context(), "garm-runner")
+			if tt.errIs == nil && tt.errMsg == "" {
+				require.NoError(t, err)
+			}
+			if tt.errIs != nil {
+				require.ErrorIs(t, err, tt.errIs)
+			}
+			if tt.errMsg != "" {
+				require.ErrorContains(t, err, tt.errMsg)
+			}
+			require.Equal(t, tt.requests, requests)
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package provider
+
+import (
+	"fmt"
+	"garm-provider-harvester/pkg/config"
+	"garm-provider-harvester/pkg/utils"
+
+	"github.com/harvester/harvester/pkg/builder"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+func nodeSelectorRequirements(reqs []config.NodeSelectorRequirement) []corev1.NodeSelectorRequirement {
+	res := make([]corev1.NodeSelectorRequirement, 0, len(reqs))
+	for _, req := range reqs {
+		res = append(res, corev1.NodeSelectorRequirement{
+			Key:      req.Key,
+			Operator: corev1.NodeSelectorOperator(req.Operator),
+			Values:   req.Values,
+		})
+	}
+	return res
+}
+
+// applyPlacement sets the node selector, node affinity and tolerations of a
+// placement on the template of a runner VM.
+func applyPlacement(spec *kubevirtv1.VirtualMachineInstanceSpec, placement config.Placement) {
+	if len(placement.NodeSelector) > 0 {
+		if spec.NodeSelector == nil {
+			spec.NodeSelector = map[string]string{}
+		}
+		for key, value := range placement.NodeSelector {
+			spec.NodeSelector[key] = value
+		}
+	}
+
+	if len(placement.RequiredNodeAffinity) > 0 || len(placement.PreferredNodeAffinity) > 0 {
+		nodeAffinity := &corev1.NodeAffinity{}
+		if len(placement.RequiredNodeAffinity) > 0 {
+			nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{MatchExpressions: nodeSelectorRequirements(placement.RequiredNodeAffinity)},
+				},
+			}
+		}
+		for _, pref := range placement.PreferredNodeAffinity {
+			nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution, corev1.PreferredSchedulingTerm{
+				Weight:     pref.Weight,
+				Preference: corev1.NodeSelectorTerm{MatchExpressions: nodeSelectorRequirements(pref.MatchExpressions)},
+			})
+		}
+		if spec.Affinity == nil {
+			spec.Affinity = &corev1.Affinity{}
+		}
+		spec.Affinity.NodeAffinity = nodeAffinity
+	}
+
+	for _, toleration := range placement.Tolerations {
+		spec.Tolerations = append(spec.Tolerations, corev1.Toleration{
+			Key:               toleration.Key,
+			Operator:          corev1.TolerationOperator(toleration.Operator),
+			Value:             toleration.Value,
+			Effect:            corev1.TaintEffect(toleration.Effect),
+			TolerationSeconds: toleration.TolerationSeconds,
+		})
+	}
+}
+
+// spreadRunners spreads the runners of a pool across topology domains. Pod
+// anti-affinity and topology spread constraints select the virt-launcher pods
+// by the pool ID label the VM template carries.
+func spreadRunners(vmBuilder *builder.VMBuilder, placement config.Placement, poolID string) *builder.VMBuilder {
+	selector := &v1.LabelSelector{
+		MatchLabels: map[string]string{fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, poolIdConst): poolID},
+	}
+
+	if placement.Spread != "" {
+		topologyKey := placement.SpreadTopologyKey
+		if topologyKey == "" {
+			topologyKey = corev1.LabelHostname
+		}
+		podAffinityTerm := corev1.PodAffinityTerm{
+			LabelSelector: selector,
+			TopologyKey:   topologyKey,
+		}
+		vmBuilder = vmBuilder.PodAntiAffinity(podAffinityTerm, placement.Spread == "soft", 100)
+	}
+
+	spec := &vmBuilder.VirtualMachine.Spec.Template.Spec
+	for _, constraint := range placement.TopologySpreadConstraints {
+		maxSkew := constraint.MaxSkew
+		if maxSkew == 0 {
+			maxSkew = 1
+		}
+		whenUnsatisfiable := corev1.DoNotSchedule
+		if constraint.WhenUnsatisfiable != "" {
+			whenUnsatisfiable = corev1.UnsatisfiableConstraintAction(constraint.WhenUnsatisfiable)
+		}
+		spec.TopologySpreadConstraints = append(spec.TopologySpreadConstraints, corev1.TopologySpreadConstraint{
+			MaxSkew:           maxSkew,
+			TopologyKey:       constraint.TopologyKey,
+			WhenUnsatisfiable: whenUnsatisfiable,
+			LabelSelector:     selector,
+		})
+	}
+	return vmBuilder
+}
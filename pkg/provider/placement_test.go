@@ -0,0 +1,73 @@
+package provider
+
+import (
+	"garm-provider-harvester/pkg/config"
+	"testing"
+
+	"github.com/harvester/harvester/pkg/builder"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+func TestApplyPlacement(t *testing.T) {
+	spec := &kubevirtv1.VirtualMachineInstanceSpec{}
+	applyPlacement(spec, config.Placement{})
+	require.Equal(t, &kubevirtv1.VirtualMachineInstanceSpec{}, spec)
+
+	applyPlacement(spec, config.Placement{
+		NodeSelector:         map[string]string{"ci.example.com/dedicated": "true"},
+		RequiredNodeAffinity: []config.NodeSelectorRequirement{{Key: "kubernetes.io/arch", Operator: "In", Values: []string{"amd64"}}},
+		PreferredNodeAffinity: []config.PreferredNodeAffinity{
+			{Weight: 50, MatchExpressions: []config.NodeSelectorRequirement{{Key: "ci.example.com/nvme", Operator: "Exists"}}},
+		},
+		Tolerations: []config.Toleration{{Key: "ci.example.com/dedicated", Operator: "Exists", Effect: "NoSchedule"}},
+	})
+
+	require.Equal(t, map[string]string{"ci.example.com/dedicated": "true"}, spec.NodeSelector)
+	require.Equal(t, []corev1.NodeSelectorTerm{{
+		MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "kubernetes.io/arch", Operator: corev1.NodeSelectorOpIn, Values: []string{"amd64"}}},
+	}}, spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms)
+	require.Equal(t, []corev1.PreferredSchedulingTerm{{
+		Weight:     50,
+		Preference: corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "ci.example.com/nvme", Operator: corev1.NodeSelectorOpExists}}},
+	}}, spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution)
+	require.Equal(t, []corev1.Toleration{
+		{Key: "ci.example.com/dedicated", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+	}, spec.Tolerations)
+}
+
+func TestSpreadRunners(t *testing.T) {
+	poolSelector := &v1.LabelSelector{MatchLabels: map[string]string{"harvesterhci.io/pool-id": "pool"}}
+
+	vm, err := spreadRunners(builder.NewVMBuilder("garm-provider"), config.Placement{}, "pool").VM()
+	require.NoError(t, err)
+	require.Nil(t, vm.Spec.Template.Spec.Affinity.PodAntiAffinity)
+	require.Empty(t, vm.Spec.Template.Spec.TopologySpreadConstraints)
+
+	vm, err = spreadRunners(builder.NewVMBuilder("garm-provider"), config.Placement{Spread: "soft"}, "pool").VM()
+	require.NoError(t, err)
+	require.Equal(t, []corev1.WeightedPodAffinityTerm{{
+		Weight:          100,
+		PodAffinityTerm: corev1.PodAffinityTerm{LabelSelector: poolSelector, TopologyKey: corev1.LabelHostname},
+	}}, vm.Spec.Template.Spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution)
+
+	vm, err = spreadRunners(builder.NewVMBuilder("garm-provider"), config.Placement{
+		Spread:            "hard",
+		SpreadTopologyKey: corev1.LabelTopologyZone,
+		TopologySpreadConstraints: []config.TopologySpreadConstraint{
+			{TopologyKey: corev1.LabelHostname, WhenUnsatisfiable: "ScheduleAnyway"},
+		},
+	}, "pool").VM()
+	require.NoError(t, err)
+	require.Equal(t, []corev1.PodAffinityTerm{
+		{LabelSelector: poolSelector, TopologyKey: corev1.LabelTopologyZone},
+	}, vm.Spec.Template.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution)
+	require.Equal(t, []corev1.TopologySpreadConstraint{{
+		MaxSkew:           1,
+		TopologyKey:       corev1.LabelHostname,
+		WhenUnsatisfiable: corev1.ScheduleAnyway,
+		LabelSelector:     poolSelector,
+	}}, vm.Spec.Template.Spec.TopologySpreadConstraints)
+}
@@ -4,25 +4,32 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"garm-provider-harvester/pkg/config"
 	"garm-provider-harvester/pkg/utils"
 	"log/slog"
+	"net"
 	"os"
-	"reflect"
 	"strings"
+	"sync"
 
-	execution "github.com/cloudbase/garm-provider-common/execution/v0.1.0"
+	"github.com/cloudbase/garm-provider-common/execution/common"
+	executionv010 "github.com/cloudbase/garm-provider-common/execution/v0.1.0"
+	executionv011 "github.com/cloudbase/garm-provider-common/execution/v0.1.1"
 	harvnetworkclient "github.com/harvester/harvester-network-controller/pkg/generated/clientset/versioned"
 	harvclient "github.com/harvester/harvester/pkg/generated/clientset/versioned"
 	"github.com/mitchellh/go-homedir"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
 	kubeschema "k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes/scheme"
 	storageclient "k8s.io/client-go/kubernetes/typed/storage/v1"
 	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/cloudbase/garm-provider-common/cloudconfig"
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
 	"github.com/cloudbase/garm-provider-common/params"
 	"github.com/cloudbase/garm-provider-common/util"
 	"github.com/harvester/harvester/pkg/builder"
@@ -34,8 +41,8 @@ import (
 )
 
 const (
-	osTypeConst = "os-type"
-	poolIdConst = "pool-id"
+	osTypeConst       = "os-type"
+	poolIdConst       = "pool-id"
 	controllerIdConst = "controller-id"
 )
 
@@ -43,9 +50,9 @@ type HarvesterProvider struct {
 	GarmConfig                *config.Config
 	RestConfig                *rest.Config
 	KubeVirtSubresourceClient *rest.RESTClient
-	KubeClient                *kubernetes.Clientset
+	KubeClient                kubernetes.Interface
 	StorageClassClient        *storageclient.StorageV1Client
-	HarvesterClient           *harvclient.Clientset
+	HarvesterClient           harvclient.Interface
 	HarvesterNetworkClient    *harvnetworkclient.Clientset
 	ControllerID              string
 }
@@ -79,9 +86,12 @@ func restConfigFromFile(kubeConfig string) (*rest.Config, error) {
 	return clientConfig.ClientConfig()
 }
 
-var _ execution.ExternalProvider = &HarvesterProvider{}
+var (
+	_ executionv010.ExternalProvider = &HarvesterProvider{}
+	_ executionv011.ExternalProvider = &HarvesterProvider{}
+)
 
-func NewHarvesterProvider(config config.Config, garmControllerId string) (execution.ExternalProvider, error) {
+func NewHarvesterProvider(config config.Config, garmControllerId string) (executionv011.ExternalProvider, error) {
 	var (
 		restConfig *rest.Config
 		err        error
@@ -136,59 +146,177 @@ func NewHarvesterProvider(config config.Config, garmControllerId string) (execut
 	}, nil
 }
 
-func (h HarvesterProvider) ListInstances(ctx context.Context, poolID string) ([]params.ProviderInstance, error) {
-	opts := v1.ListOptions{}
-	vms, err := h.HarvesterClient.KubevirtV1().VirtualMachineInstances(h.GarmConfig.Namespace).List(ctx, opts)
+// ListInstances implements executionv011.ExternalProvider.
+// VirtualMachines are listed rather than VirtualMachineInstances so that stopped
+// and unscheduled runners stay visible to GARM.
+func (h *HarvesterProvider) ListInstances(ctx context.Context, poolID string) ([]params.ProviderInstance, error) {
+	selector := labels.SelectorFromSet(labels.Set{
+		fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, poolIdConst):       poolID,
+		fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, controllerIdConst): h.ControllerID,
+	})
+	opts := v1.ListOptions{LabelSelector: selector.String()}
+	vms, err := h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).List(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, apiError(err, "failed to list VMs for pool %s", poolID)
 	}
-	var res []params.ProviderInstance
-	for _, vm := range vms.Items {
-		if vm.Labels[poolIdConst] == poolID || true {
-			res = append(res, utils.HarvesterVmToInstance(&vm))
-		}
+	vmis, err := h.HarvesterClient.KubevirtV1().VirtualMachineInstances(h.GarmConfig.Namespace).List(ctx, opts)
+	if err != nil {
+		return nil, apiError(err, "failed to list VMIs for pool %s", poolID)
+	}
+	vmiByName := make(map[string]*kubevirtv1.VirtualMachineInstance, len(vmis.Items))
+	for i := range vmis.Items {
+		vmiByName[vmis.Items[i].Name] = &vmis.Items[i]
+	}
+
+	res := make([]params.ProviderInstance, 0, len(vms.Items))
+	for i := range vms.Items {
+		res = append(res, utils.HarvesterVmToInstance(&vms.Items[i], vmiByName[vms.Items[i].Name]))
 	}
 	return res, nil
 }
 
+// getVM looks a runner VM up by its provider ID. The provider ID of a runner is
+// the name of its VM, which is the lower cased GARM instance name. Older releases
+// reported the VM UID from GetInstance and ListInstances, so IDs that do not
+// match a VM name are resolved against the UIDs of this controller's VMs.
+func (h *HarvesterProvider) getVM(ctx context.Context, instance string) (*kubevirtv1.VirtualMachine, error) {
+	vm, err := h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).Get(ctx, strings.ToLower(instance), v1.GetOptions{})
+	if err == nil || !apierrors.IsNotFound(err) {
+		return vm, err
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{
+		fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, controllerIdConst): h.ControllerID,
+	})
+	vms, listErr := h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).List(ctx, v1.ListOptions{LabelSelector: selector.String()})
+	if listErr != nil {
+		return nil, listErr
+	}
+	for i := range vms.Items {
+		if string(vms.Items[i].UID) == instance {
+			return &vms.Items[i], nil
+		}
+	}
+	return nil, err
+}
+
+// getVMI returns the VirtualMachineInstance of a VM, or nil if the VM is not running.
+func (h *HarvesterProvider) getVMI(ctx context.Context, name string) (*kubevirtv1.VirtualMachineInstance, error) {
+	vmi, err := h.HarvesterClient.KubevirtV1().VirtualMachineInstances(h.GarmConfig.Namespace).Get(ctx, name, v1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return vmi, nil
+}
 
 type ImageMetadataStatus struct {
-	StorageClassName string				`json:"storageClassName"`
+	StorageClassName string           `json:"storageClassName"`
+	Size             int64            `json:"size"`
+	VirtualSize      int64            `json:"virtualSize"`
+	Progress         int              `json:"progress"`
+	Conditions       []ImageCondition `json:"conditions"`
+}
+
+type ImageCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
 }
 
 type ItemMetadata struct {
-	Name string							`json:"name"`
+	Name string `json:"name"`
 }
 
 type Item struct {
-	Status ImageMetadataStatus			`json:"status"`
-	Metadata ItemMetadata 				`json:"metadata"`
+	Status   ImageMetadataStatus `json:"status"`
+	Metadata ItemMetadata        `json:"metadata"`
 }
 
 type ImageList struct {
-	Items []Item 						`json:"items"`
+	Items []Item `json:"items"`
 }
 
-// /kubectl get virtualmachineimages.harvesterhci.io -n harvester-public -o jsonpath='{.items[?(@.metadata.labels.harvesterhci\.io\/imageDisplayName == "ubuntu-server-noble-24.04")].status.storageClassName}' 
+// /kubectl get virtualmachineimages.harvesterhci.io -n harvester-public -o jsonpath='{.items[?(@.metadata.labels.harvesterhci\.io\/imageDisplayName == "ubuntu-server-noble-24.04")].status.storageClassName}'
 func (h *HarvesterProvider) getStorageClass(ctx context.Context, imageName string) (string, error) {
+	img, err := h.getImage(ctx, imageName)
+	if err != nil {
+		return "", err
+	}
+	return img.Status.StorageClassName, nil
+}
+
+// getImage returns the VirtualMachineImage given as <namespace>/<name>.
+func (h *HarvesterProvider) getImage(ctx context.Context, imageName string) (*Item, error) {
 	ns := strings.Split(imageName, "/")[0]
 	name := strings.Join(strings.Split(imageName, "/")[1:], "/")
-	l, err := h.KubeClient.RESTClient().Get().AbsPath(fmt.Sprintf("/apis/harvesterhci.io/v1beta1/namespaces/%s/virtualmachineimages", ns)).DoRaw(ctx)
+	l, err := h.KubeClient.Discovery().RESTClient().Get().AbsPath(fmt.Sprintf("/apis/harvesterhci.io/v1beta1/namespaces/%s/virtualmachineimages", ns)).DoRaw(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to query storage class for backingimage %s: %s", name, err)
+		return nil, apiError(err, "failed to query storage class for backingimage %s", name)
 	}
 	imagesList := &ImageList{}
 	if err := json.Unmarshal(l, &imagesList); err != nil {
-		return "", fmt.Errorf("failed to unmarshal imagelist JSON for %s: %s", imageName, err)
+		return nil, fmt.Errorf("failed to unmarshal imagelist JSON for %s: %s", imageName, err)
 	}
 	for _, img := range imagesList.Items {
 		if img.Metadata.Name == name {
-			return img.Status.StorageClassName, nil
+			return &img, nil
 		}
 	}
-	return "", fmt.Errorf("backing image %s not found", imageName)
+	return nil, fmt.Errorf("backing image %s: %w", imageName, garmErrors.ErrNotFound)
 }
 
+// checkImageReady fails unless an image finished importing and got the
+// storage class its volumes are provisioned with.
+func checkImageReady(img *Item) error {
+	for _, cond := range img.Status.Conditions {
+		if cond.Type != "Imported" {
+			continue
+		}
+		if cond.Status != "True" {
+			return fmt.Errorf("image %s is not imported yet (%d%%): %s", img.Metadata.Name, img.Status.Progress, cond.Message)
+		}
+		if img.Status.StorageClassName == "" {
+			return fmt.Errorf("image %s has no storage class", img.Metadata.Name)
+		}
+		return nil
+	}
+	return fmt.Errorf("image %s is not imported yet (%d%%)", img.Metadata.Name, img.Status.Progress)
+}
+
+// bootDiskSize returns the size of the boot disk: the boot_disk_size extra
+// spec, else the boot_disk_size of the provider config, else the flavor disk.
+// The size is checked against the virtual size of the image.
+func (h *HarvesterProvider) bootDiskSize(flavorDisk string, extraSpec *config.HarvesterExtraSpec, img *Item) (string, error) {
+	size := flavorDisk
+	if h.GarmConfig.BootDiskSize != "" {
+		size = h.GarmConfig.BootDiskSize
+	}
+	if extraSpec.BootDiskSize != "" {
+		size = extraSpec.BootDiskSize
+	}
+	if err := checkDiskSize(size, img); err != nil {
+		return "", fmt.Errorf("%w: %w", garmErrors.ErrBadRequest, err)
+	}
+	return size, nil
+}
+
+// checkDiskSize fails if a disk of the given size cannot hold the image.
+// Images that did not report their virtual size yet are not checked.
+func checkDiskSize(size string, img *Item) error {
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return fmt.Errorf("invalid disk size %s: %w", size, err)
+	}
+	if img.Status.VirtualSize > 0 && quantity.Value() < img.Status.VirtualSize {
+		return fmt.Errorf("disk size %s is smaller than the virtual size %s of image %s",
+			size, resource.NewQuantity(img.Status.VirtualSize, resource.BinarySI), img.Metadata.Name)
+	}
+	return nil
+}
 
 // CreateInstance implements executionv011.ExternalProvider.
 func (h *HarvesterProvider) CreateInstance(ctx context.Context, bootstrapParams params.BootstrapInstance) (params.ProviderInstance, error) {
@@ -199,26 +327,14 @@ func (h *HarvesterProvider) CreateInstance(ctx context.Context, bootstrapParams
 
 	extraSpec := &config.HarvesterExtraSpec{}
 	if err := json.Unmarshal(bootstrapParams.ExtraSpecs, &extraSpec); err != nil {
-		return params.ProviderInstance{}, fmt.Errorf("failed to unmarshal extra spec JSON for %s: %s", bootstrapParams.Name, err)
+		return params.ProviderInstance{}, fmt.Errorf("failed to unmarshal extra spec JSON for %s: %w: %s", bootstrapParams.Name, garmErrors.ErrBadRequest, err)
 	}
 	err := extraSpec.Validate()
 	if err != nil {
-		return params.ProviderInstance{}, fmt.Errorf("failed to validate extra spec for %s: %s", bootstrapParams.Name, err)
+		return params.ProviderInstance{}, fmt.Errorf("failed to validate extra spec for %s: %w: %s", bootstrapParams.Name, garmErrors.ErrBadRequest, err)
 	}
 
 	// Set defaults
-	var networkName = ""
-	if extraSpec.NetworkName != "" {
-		networkName = extraSpec.NetworkName
-	}
-	var networkAdapterType = "virtio"
-	if extraSpec.NetworkAdapterType != "" {
-		networkAdapterType = extraSpec.NetworkAdapterType
-	}
-	var networkType = "masquerade"
-	if extraSpec.NetworkType != "" {
-		networkType = extraSpec.NetworkType
-	}
 	var diskConnectorType = "virtio"
 	if extraSpec.DiskConnectorType != "" {
 		diskConnectorType = extraSpec.DiskConnectorType
@@ -227,13 +343,16 @@ func (h *HarvesterProvider) CreateInstance(ctx context.Context, bootstrapParams
 	// Get resources
 	cores, memory, disk, err := utils.ParseFlavor(bootstrapParams.Flavor)
 	if err != nil {
-		return params.ProviderInstance{}, err
+		return params.ProviderInstance{}, fmt.Errorf("invalid flavor %s: %w: %s", bootstrapParams.Flavor, garmErrors.ErrBadRequest, err)
+	}
+	if err := checkCPUAndMemory(extraSpec, cores, memory); err != nil {
+		return params.ProviderInstance{}, fmt.Errorf("invalid flavor %s for extra spec of %s: %w: %w", bootstrapParams.Flavor, bootstrapParams.Name, garmErrors.ErrBadRequest, err)
 	}
 
 	// Get labels
 	labels := map[string]string{
-		fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, osTypeConst): string(bootstrapParams.OSType),
-		fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, poolIdConst): bootstrapParams.PoolID,
+		fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, osTypeConst):       string(bootstrapParams.OSType),
+		fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, poolIdConst):       bootstrapParams.PoolID,
 		fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, controllerIdConst): h.ControllerID,
 	}
 
@@ -251,7 +370,7 @@ func (h *HarvesterProvider) CreateInstance(ctx context.Context, bootstrapParams
 		}
 	}
 	if runnerTool == (params.RunnerApplicationDownload{}) {
-		return params.ProviderInstance{}, fmt.Errorf("no tools found for %s %s", gitArch, string(bootstrapParams.OSType))
+		return params.ProviderInstance{}, fmt.Errorf("no tools found for %s %s: %w", gitArch, string(bootstrapParams.OSType), garmErrors.ErrBadRequest)
 	}
 	slog.Info(fmt.Sprintf("%s: got tools", bootstrapParams.Name))
 
@@ -260,6 +379,15 @@ func (h *HarvesterProvider) CreateInstance(ctx context.Context, bootstrapParams
 	if err != nil {
 		return params.ProviderInstance{}, err
 	}
+	if mounts := diskMounts(extraSpec.Disks); len(mounts) > 0 {
+		if bootstrapParams.OSType != params.Linux {
+			return params.ProviderInstance{}, fmt.Errorf("mount_path of disks is only supported on Linux: %w", garmErrors.ErrBadRequest)
+		}
+		userData, err = utils.AddDiskMounts(userData, mounts)
+		if err != nil {
+			return params.ProviderInstance{}, fmt.Errorf("failed to add disk mounts for %s: %w", bootstrapParams.Name, err)
+		}
+	}
 	var cloudConfigSecret corev1.Secret
 	var cloudInitSource builder.CloudInitSource
 	if len(userData) > utils.CloudInitNoCloudLimitSize {
@@ -267,6 +395,7 @@ func (h *HarvesterProvider) CreateInstance(ctx context.Context, bootstrapParams
 			ObjectMeta: v1.ObjectMeta{
 				Name:      fmt.Sprintf("%s-%s", strings.ToLower(bootstrapParams.Name), "cloudinit"),
 				Namespace: h.GarmConfig.Namespace,
+				Labels:    labels,
 			},
 			Data: map[string][]byte{},
 		}
@@ -283,14 +412,18 @@ func (h *HarvesterProvider) CreateInstance(ctx context.Context, bootstrapParams
 	}
 	slog.Info(fmt.Sprintf("%s: cloud-init ready", bootstrapParams.Name))
 
-	storageClass, err := h.getStorageClass(ctx, bootstrapParams.Image)
+	img, err := h.getImage(ctx, bootstrapParams.Image)
 	if err != nil {
-		slog.Info(fmt.Sprintf("%s: failed to find storage class %s %s: %s", bootstrapParams.Name, bootstrapParams.Image, storageClass, err.Error()))
+		slog.Info(fmt.Sprintf("%s: failed to find storage class %s: %s", bootstrapParams.Name, bootstrapParams.Image, err.Error()))
 		return params.ProviderInstance{}, err
 	}
+	storageClass := img.Status.StorageClassName
+	disk, err = h.bootDiskSize(disk, extraSpec, img)
+	if err != nil {
+		return params.ProviderInstance{}, fmt.Errorf("invalid boot disk size for %s: %w", bootstrapParams.Name, err)
+	}
 	slog.Info(fmt.Sprintf("%s: boot image resolved", bootstrapParams.Name))
 
-
 	// Boot Disk
 	pvcOption := &builder.PersistentVolumeClaimOption{
 		ImageID:          bootstrapParams.Image,
@@ -298,54 +431,63 @@ func (h *HarvesterProvider) CreateInstance(ctx context.Context, bootstrapParams
 		AccessMode:       corev1.ReadWriteMany,
 		StorageClassName: &storageClass,
 		Annotations: map[string]string{
-			"terraform-provider-harvester-auto-delete": "true",
+			autoDeleteAnnotation: "true",
+			fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, controllerIdConst): h.ControllerID,
 		},
 	}
 
+	if err := h.checkMACAddresses(ctx, strings.ToLower(bootstrapParams.Name), extraSpec.NetworkInterfaces()); err != nil {
+		return params.ProviderInstance{}, err
+	}
+
 	// Build VM
-	vmBuilder := builder.NewVMBuilder("garm-provider").NetworkInterface("nic-0", networkAdapterType, "", networkType, networkName).
+	vmBuilder := builder.NewVMBuilder("garm-provider").
 		Namespace(h.GarmConfig.Namespace).Name(strings.ToLower(bootstrapParams.Name)).CPU(cores).Memory(memory).
 		PVCDisk("rootdisk", diskConnectorType, false, false, 1, disk, "", pvcOption).
 		CloudInitDisk(builder.CloudInitDiskName, builder.DiskBusVirtio, false, 0, cloudInitSource).
 		EvictionStrategy(true).RunStrategy(kubevirtv1.RunStrategyRerunOnFailure).Labels(labels)
+	for i, nic := range extraSpec.NetworkInterfaces() {
+		vmBuilder = vmBuilder.NetworkInterface(fmt.Sprintf("nic-%d", i), nic.NetworkAdapterType, nic.MACAddress, nic.NetworkType, nic.NetworkName)
+	}
+	vmBuilder = h.addExtraDisks(vmBuilder, extraSpec.Disks)
+	vmBuilder = addDevices(vmBuilder, extraSpec.GPUs, extraSpec.HostDevices)
+	firmware, tpm, hyperV := extraSpec.FirmwareSettings(bootstrapParams.OSType == params.Windows)
+	if tpm {
+		vmBuilder = vmBuilder.TPM()
+	}
+
+	placement := extraSpec.Placement.WithDefaults(h.GarmConfig.Placement)
+	vmBuilder = spreadRunners(vmBuilder, placement, bootstrapParams.PoolID)
 
 	vm, err := vmBuilder.VM()
 	if err != nil {
 		return params.ProviderInstance{}, err
 	}
+	setDiskSerials(vm, extraSpec.Disks)
+	applyPlacement(&vm.Spec.Template.Spec, placement)
+	if err := applyCPU(&vm.Spec.Template.Spec, extraSpec, cores); err != nil {
+		return params.ProviderInstance{}, fmt.Errorf("invalid cpu topology for %s: %w: %w", bootstrapParams.Name, garmErrors.ErrBadRequest, err)
+	}
+	applyFirmware(&vm.Spec.Template.Spec, firmware)
+	if hyperV {
+		applyHyperV(&vm.Spec.Template.Spec)
+	}
 	vm.Kind = kubevirtv1.VirtualMachineGroupVersionKind.Kind
 	vm.APIVersion = kubevirtv1.GroupVersion.String()
 
-	// Create VM
-	opts := v1.CreateOptions{}
-	var res *kubevirtv1.VirtualMachine
-	res, err = h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).Create(ctx, vm, opts)
+	// Create VM and cloud-init secret
+	var secret *corev1.Secret
+	if cloudConfigSecret.Data != nil {
+		secret = &cloudConfigSecret
+	}
+	res, err := h.createRunner(ctx, vm, secret)
 	if err != nil {
 		return params.ProviderInstance{}, err
 	}
-	slog.Info(fmt.Sprintf("%s: instance created", bootstrapParams.Name))
-
-
-	// Create cloud-init secret
-	if cloudConfigSecret.Data != nil {
-		cloudConfigSecret.OwnerReferences = []v1.OwnerReference{
-			{
-				APIVersion: vm.APIVersion,
-				Kind:       vm.Kind,
-				Name:       strings.ToLower(vm.Name),
-				UID:        res.UID,
-			},
-		}
-		_, err = h.KubeClient.CoreV1().Secrets(h.GarmConfig.Namespace).Create(ctx, &cloudConfigSecret, v1.CreateOptions{})
-		if err != nil {
-			return params.ProviderInstance{}, err
-		}
-	}
 	slog.Info(fmt.Sprintf("%s: sucess exiting", bootstrapParams.Name))
 
-	// params.InstanceStatus(utils.StatusMap[string(vm.Status.PrintableStatus)])
 	return params.ProviderInstance{
-		ProviderID: strings.ToLower(bootstrapParams.Name),
+		ProviderID: res.Name,
 		Name:       res.Name,
 		OSArch:     params.OSArch(res.Spec.Template.Spec.Architecture),
 		OSType:     params.OSType(params.OSType(vm.Labels[fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, osTypeConst)])),
@@ -353,76 +495,75 @@ func (h *HarvesterProvider) CreateInstance(ctx context.Context, bootstrapParams
 	}, nil
 }
 
-
-func  (h *HarvesterProvider) vpcsToRemove(ctx context.Context, vm *kubevirtv1.VirtualMachine) ([]string, error) {
-	deleteConfigs := make(map[string]bool)
-	removedPVCs := make([]string, 0, len(vm.Spec.Template.Spec.Volumes))
-	for _, volume := range vm.Spec.Template.Spec.Volumes {
-		if volume.PersistentVolumeClaim == nil {
-			continue
-		}
-		if autoDelete, ok := deleteConfigs[volume.Name]; ok && !autoDelete {
-			continue
-		}
-
-		// h.KubeClient.StorageV1().VolumeAttachments().Delete(ctx, volume.Att)
-
-		removedPVCs = append(removedPVCs, volume.PersistentVolumeClaim.ClaimName)
-	}
-	return removedPVCs, nil
-}
 // DeleteInstance implements executionv011.ExternalProvider.
 func (h *HarvesterProvider) DeleteInstance(ctx context.Context, instance string) error {
-	vm, err := h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).Get(ctx, strings.ToLower(instance), v1.GetOptions{})
+	vm, err := h.getVM(ctx, instance)
 	if err != nil {
-		if apierrors.IsNotFound(err) {
-			return fmt.Errorf("instance %s not found: %s", strings.ToLower(instance), err.Error())
-		}
+		return apiError(err, "failed to get instance %s", strings.ToLower(instance))
+	}
+
+	if err := h.checkOwner(vm); err != nil {
 		return err
 	}
 
-	val, ok := vm.Labels[fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, controllerIdConst)]
-	if !ok || val != h.ControllerID {
-		return fmt.Errorf("found instance %s but doesn't have label %s/%s=%s", strings.ToLower(instance), utils.HarvesterAPIGroup, controllerIdConst, h.ControllerID)
+	return h.removeVM(ctx, vm)
+}
+
+// removeVM deletes a runner VM and, with delete_wait set, waits for its
+// teardown.
+func (h *HarvesterProvider) removeVM(ctx context.Context, vm *kubevirtv1.VirtualMachine) error {
+	pvcs, err := h.deleteVM(ctx, vm)
+	if err != nil || !h.GarmConfig.DeleteWait {
+		return err
 	}
+	return h.waitForTeardown(ctx, vm, pvcs, h.GarmConfig.DeleteTimeout.OrDefault(config.DefaultDeleteTimeout))
+}
 
+// deleteVM removes a runner VM in the foreground together with the PVCs it
+// leaves behind, and returns the PVCs it removed.
+func (h *HarvesterProvider) deleteVM(ctx context.Context, vm *kubevirtv1.VirtualMachine) ([]string, error) {
 	pvcsToRemove, err := h.vpcsToRemove(ctx, vm)
 	if err != nil {
-			return fmt.Errorf("failed to find vpcs for %s: %s", strings.ToLower(instance), err.Error())
+		return nil, fmt.Errorf("failed to find pvcs of %s: %w", vm.Name, err)
+	}
+	volumes, err := h.boundVolumes(ctx, pvcsToRemove)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find volumes of %s: %w", vm.Name, err)
 	}
-
 
 	propagationPolicy := v1.DeletePropagationForeground
 	deleteOptions := v1.DeleteOptions{PropagationPolicy: &propagationPolicy}
-	err = h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).Delete(ctx, strings.ToLower(instance), deleteOptions)
+	err = h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).Delete(ctx, vm.Name, deleteOptions)
 	if err != nil {
 		if apierrors.IsNotFound(err) {
-			slog.Info(fmt.Sprintf("instance %s not found",instance))
-			return nil
+			slog.Info(fmt.Sprintf("instance %s not found", vm.Name))
+			return nil, nil
 		}
-		return err
+		return nil, apiError(err, "failed to delete instance %s", vm.Name)
 	}
 
 	for _, pvc := range pvcsToRemove {
-		propagationPolicy := v1.DeletePropagationForeground
-		deleteOptions := v1.DeleteOptions{PropagationPolicy: &propagationPolicy}
 		err := h.KubeClient.CoreV1().PersistentVolumeClaims(h.GarmConfig.Namespace).Delete(ctx, pvc, deleteOptions)
-		if err != nil {
-			return fmt.Errorf("failed to remove %s pvc %s: %s", instance, pvc, err.Error())
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, apiError(err, "failed to remove %s pvc %s", vm.Name, pvc)
 		}
 	}
 
-	return nil
+	h.removeStaleVolumeAttachments(ctx, vm.Name, volumes)
+	return pvcsToRemove, nil
 }
 
 // GetInstance implements executionv011.ExternalProvider.
 func (h *HarvesterProvider) GetInstance(ctx context.Context, instance string) (params.ProviderInstance, error) {
-	opts := v1.GetOptions{}
-	vm, err := h.HarvesterClient.KubevirtV1().VirtualMachineInstances(h.GarmConfig.Namespace).Get(ctx, strings.ToLower(instance), opts)
+	vm, err := h.getVM(ctx, instance)
 	if err != nil {
-		return params.ProviderInstance{}, fmt.Errorf("failed to get instance %s: %s", strings.ToLower(instance), err.Error())
+		return params.ProviderInstance{}, apiError(err, "failed to get instance %s", strings.ToLower(instance))
 	}
-	return utils.HarvesterVmToInstance(vm), nil
+	vmi, err := h.getVMI(ctx, vm.Name)
+	if err != nil {
+		return params.ProviderInstance{}, apiError(err, "failed to get VMI of instance %s", vm.Name)
+	}
+	return utils.HarvesterVmToInstance(vm, vmi), nil
 }
 
 // GetVersion implements executionv011.ExternalProvider.
@@ -431,71 +572,248 @@ func (h *HarvesterProvider) GetVersion(ctx context.Context) string {
 }
 
 // RemoveAllInstances implements executionv011.ExternalProvider.
+// VMs are removed by a pool of delete_concurrency workers, each waiting for the
+// teardown of its VM when delete_wait is set. A failed removal does not stop
+// the others; every failure is returned in a joined error.
 func (h *HarvesterProvider) RemoveAllInstances(ctx context.Context) error {
-	opts := v1.ListOptions{}
+	selector := labels.SelectorFromSet(labels.Set{
+		fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, controllerIdConst): h.ControllerID,
+	})
+	opts := v1.ListOptions{LabelSelector: selector.String()}
 	vms, err := h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).List(ctx, opts)
 	if err != nil {
-		return fmt.Errorf("failed to get VM list for NS %s: %s", h.GarmConfig.Namespace, err.Error())
+		return apiError(err, "failed to get VM list for NS %s", h.GarmConfig.Namespace)
+	}
+
+	workers := h.GarmConfig.DeleteConcurrency
+	if workers == 0 {
+		workers = config.DefaultDeleteConcurrency
+	}
+	queue := make(chan *kubevirtv1.VirtualMachine)
+	results := make(chan error, len(vms.Items))
+	var wg sync.WaitGroup
+	for range min(workers, len(vms.Items)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for vm := range queue {
+				results <- h.removeVM(ctx, vm)
+			}
+		}()
 	}
-	for _, vm := range vms.Items {
-		val, ok := vm.Labels[fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, controllerIdConst)]
-		if !ok || val != h.ControllerID {
-			slog.Debug(fmt.Sprintf("found instance %s but doesn't have label %s/%s=%s", strings.ToLower(vm.Name), utils.HarvesterAPIGroup, controllerIdConst, h.ControllerID))
-			continue
-		}
-
-		pvcsToRemove, err := h.vpcsToRemove(ctx, &vm)
-		if err != nil {
-			return fmt.Errorf("failed to find vpcs for: %s", err.Error())
-		}
+	for i := range vms.Items {
+		queue <- &vms.Items[i]
+	}
+	close(queue)
+	wg.Wait()
+	close(results)
 
-		err = h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).Delete(ctx, vm.Name, v1.DeleteOptions{})
+	var errs []error
+	for err := range results {
 		if err != nil {
-			return fmt.Errorf("failed to delete virtual machine %s: %s", vm.Name, err.Error())
-		}
-
-		for _, pvc := range pvcsToRemove {
-			propagationPolicy := v1.DeletePropagationForeground
-			deleteOptions := v1.DeleteOptions{PropagationPolicy: &propagationPolicy}
-			err := h.KubeClient.CoreV1().PersistentVolumeClaims(h.GarmConfig.Namespace).Delete(ctx, pvc, deleteOptions)
-			if err != nil {
-				return fmt.Errorf("failed to remove %s pvc %s: %s", vm.Name, pvc, err.Error())
-			}
+			errs = append(errs, err)
 		}
 	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to remove %d of %d instances: %w", len(errs), len(vms.Items), errors.Join(errs...))
+	}
 	return nil
 }
 
 // Start implements executionv011.ExternalProvider.
+// Starting a VM that is already running or starting is a no-op.
 func (h *HarvesterProvider) Start(ctx context.Context, instance string) error {
-	opts := v1.GetOptions{}
-	vm, err := h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).Get(ctx, strings.ToLower(instance), opts)
+	vm, err := h.getVM(ctx, instance)
 	if err != nil {
-		return err
+		return apiError(err, "failed to get instance %s", strings.ToLower(instance))
 	}
-	vmCopy := vm.DeepCopy()
-	runStrategy := kubevirtv1.RunStrategyAlways
-	vmCopy.Spec.RunStrategy = &runStrategy
-	if !reflect.DeepEqual(vm, vmCopy) {
-		_, err = h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).Update(ctx, vmCopy, v1.UpdateOptions{})
+
+	name := vm.Name
+	err = retryOnConflict(ctx, func(ctx context.Context) error {
+		vm, vmi, err := h.getVMAndVMI(ctx, name)
+		if err != nil {
+			return err
+		}
+		if isStarted(vm, vmi) {
+			return nil
+		}
+		_, err = h.changeRunStrategy(ctx, vm, "start", &kubevirtv1.StartOptions{}, kubevirtv1.RunStrategyAlways)
 		return err
+	})
+	if err != nil {
+		return apiError(err, "failed to start instance %s", name)
 	}
-	return fmt.Errorf("VM runstrategy is already set to %s", runStrategy)
+	return nil
 }
 
 // Stop implements executionv011.ExternalProvider.
+// A forced stop powers the VM off straight away. Otherwise the guest gets an ACPI
+// shutdown and the configured grace period to finish deregistering, and Stop
+// waits up to the stop timeout for it to power off. GARM always forces the
+// stop; graceful stops come from the stop operator command, see Shutdown.
+// Stopping a VM that is already stopped is a no-op.
 func (h *HarvesterProvider) Stop(ctx context.Context, instance string, force bool) error {
-	opts := v1.GetOptions{}
-	vm, err := h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).Get(ctx, strings.ToLower(instance), opts)
+	vm, err := h.getVM(ctx, instance)
+	if err != nil {
+		return apiError(err, "failed to get instance %s", strings.ToLower(instance))
+	}
+
+	gracePeriod := int64(0)
+	if !force {
+		gracePeriod = int64(h.GarmConfig.ShutdownGracePeriod.OrDefault(config.DefaultShutdownGracePeriod).Seconds())
+	}
+
+	name := vm.Name
+	stopped := false
+	err = retryOnConflict(ctx, func(ctx context.Context) error {
+		vm, vmi, err := h.getVMAndVMI(ctx, name)
+		if err != nil {
+			return err
+		}
+		if isStopped(vm, vmi) {
+			stopped = true
+			return nil
+		}
+		if isStopping(vm, vmi) && !force {
+			return nil
+		}
+		updated, err := h.changeRunStrategy(ctx, vm, "stop", &kubevirtv1.StopOptions{GracePeriod: &gracePeriod}, kubevirtv1.RunStrategyHalted)
+		if err != nil || !updated || !force || vmi == nil {
+			return err
+		}
+		return h.killVMI(ctx, name)
+	})
+	if err != nil {
+		return apiError(err, "failed to stop instance %s", name)
+	}
+	if force || stopped {
+		return nil
+	}
+	return h.waitForVMIGone(ctx, name, h.GarmConfig.StopTimeout.OrDefault(config.DefaultStopTimeout))
+}
+
+// GetSupportedInterfaceVersions implements executionv011.ExternalProvider.
+func (h *HarvesterProvider) GetSupportedInterfaceVersions(ctx context.Context) []string {
+	return []string{common.Version010, common.Version011}
+}
+
+// ValidatePoolInfo implements executionv011.ExternalProvider.
+func (h *HarvesterProvider) ValidatePoolInfo(ctx context.Context, image string, flavor string, providerConfig string, extraspecs string) error {
+	cores, memory, disk, err := utils.ParseFlavor(flavor)
+	if err != nil {
+		return fmt.Errorf("invalid flavor %q, expected one of small, medium, large, xlarge or custom-<cores>c-<memory>-<disk>: %w: %w", flavor, garmErrors.ErrBadRequest, err)
+	}
+
+	if !strings.Contains(image, "/") {
+		return fmt.Errorf("invalid image %q, expected <namespace>/<virtualmachineimage name>: %w", image, garmErrors.ErrBadRequest)
+	}
+	img, err := h.getImage(ctx, image)
 	if err != nil {
+		if errors.Is(err, garmErrors.ErrNotFound) {
+			return fmt.Errorf("invalid image %q, no such VirtualMachineImage: %w: %w", image, garmErrors.ErrBadRequest, err)
+		}
 		return err
 	}
-	vmCopy := vm.DeepCopy()
-	runStrategy := kubevirtv1.RunStrategyHalted
-	vmCopy.Spec.RunStrategy = &runStrategy
-	if !reflect.DeepEqual(vm, vmCopy) {
-		_, err = h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).Update(ctx, vmCopy, v1.UpdateOptions{})
+	if err := checkImageReady(img); err != nil {
+		return fmt.Errorf("invalid image %q: %w: %w", image, garmErrors.ErrBadRequest, err)
+	}
+
+	extraSpec := &config.HarvesterExtraSpec{}
+	if extraspecs != "" {
+		if err := json.Unmarshal([]byte(extraspecs), extraSpec); err != nil {
+			return fmt.Errorf("failed to unmarshal extra specs: %w: %w", garmErrors.ErrBadRequest, err)
+		}
+	}
+	if err := extraSpec.Validate(); err != nil {
+		return fmt.Errorf("invalid extra specs: %w: %w", garmErrors.ErrBadRequest, err)
+	}
+
+	if err := checkCPUAndMemory(extraSpec, cores, memory); err != nil {
+		return fmt.Errorf("invalid flavor %q for extra specs: %w: %w", flavor, garmErrors.ErrBadRequest, err)
+	}
+
+	if _, err := h.bootDiskSize(disk, extraSpec, img); err != nil {
+		return fmt.Errorf("invalid boot disk size: %w", err)
+	}
+
+	for _, nic := range extraSpec.NetworkInterfaces() {
+		if nic.NetworkName == "" {
+			continue
+		}
+		if err := h.validateNetwork(ctx, nic.NetworkName); err != nil {
+			return err
+		}
+	}
+
+	if err := h.validateDisks(ctx, extraSpec.Disks); err != nil {
+		return err
+	}
+
+	if err := h.validateDevices(ctx, extraSpec.GPUs, extraSpec.HostDevices); err != nil {
 		return err
 	}
-	return fmt.Errorf("VM runstrategy is already set to %s", runStrategy)
+
+	return nil
+}
+
+// validateNetwork checks that the NetworkAttachmentDefinition referenced by a
+// network_name extra spec exists. Names without a namespace are looked up in
+// the runner namespace.
+func (h *HarvesterProvider) validateNetwork(ctx context.Context, networkName string) error {
+	ns, name := h.GarmConfig.Namespace, networkName
+	if before, after, ok := strings.Cut(networkName, "/"); ok {
+		ns, name = before, after
+	}
+	_, err := h.HarvesterClient.K8sCniCncfIoV1().NetworkAttachmentDefinitions(ns).Get(ctx, name, v1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("invalid network_name %q, NetworkAttachmentDefinition %s not found in namespace %s: %w", networkName, name, ns, garmErrors.ErrBadRequest)
+		}
+		return apiError(err, "failed to get network %s", networkName)
+	}
+	return nil
+}
+
+// checkMACAddresses refuses to create a runner with a fixed MAC address that
+// another VM in the runner namespace already has. A mac_address in the extra
+// specs is the same for every runner of the pool, so only a pool of one
+// runner can use it.
+func (h *HarvesterProvider) checkMACAddresses(ctx context.Context, name string, nics []config.NetworkSpec) error {
+	macs := map[string]bool{}
+	for _, nic := range nics {
+		if nic.MACAddress != "" {
+			mac, _ := net.ParseMAC(nic.MACAddress)
+			macs[mac.String()] = true
+		}
+	}
+	if len(macs) == 0 {
+		return nil
+	}
+
+	vms, err := h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return apiError(err, "failed to get VM list for NS %s", h.GarmConfig.Namespace)
+	}
+	for _, vm := range vms.Items {
+		if vm.Name == name || vm.Spec.Template == nil {
+			continue
+		}
+		for _, iface := range vm.Spec.Template.Spec.Domain.Devices.Interfaces {
+			mac, err := net.ParseMAC(iface.MacAddress)
+			if err == nil && macs[mac.String()] {
+				return fmt.Errorf("mac_address %s is already used by %s, a pool with a mac_address can only have one runner: %w", mac, vm.Name, garmErrors.ErrBadRequest)
+			}
+		}
+	}
+	return nil
+}
+
+// GetConfigJSONSchema implements executionv011.ExternalProvider.
+func (h *HarvesterProvider) GetConfigJSONSchema(ctx context.Context) (string, error) {
+	return config.ConfigJSONSchema()
+}
+
+// GetExtraSpecsJSONSchema implements executionv011.ExternalProvider.
+func (h *HarvesterProvider) GetExtraSpecsJSONSchema(ctx context.Context) (string, error) {
+	return config.ExtraSpecsJSONSchema()
 }
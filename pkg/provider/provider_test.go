@@ -1,11 +1,28 @@
 package provider
 
 import (
+	"errors"
+	"fmt"
 	"garm-provider-harvester/pkg/config"
+	"garm-provider-harvester/pkg/utils"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
+	harvfake "github.com/harvester/harvester/pkg/generated/clientset/versioned/fake"
 	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeschema "k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+	kubevirtv1 "kubevirt.io/api/core/v1"
 )
 
 // func TestGetBackingImage(t *testing.T) {
@@ -44,4 +61,204 @@ func TestGetStorageClass(t *testing.T) {
 		log.Fatalf("Failed to get backing image: %s", err)
 	}
 	require.Equal(t, "longhorn-ubuntu-server-noble-24.04", res)
-}
\ No newline at end of file
+}
+
+func TestGetSupportedInterfaceVersions(t *testing.T) {
+	h := &HarvesterProvider{}
+	require.Equal(t, []string{"v0.1.0", "v0.1.1"}, h.GetSupportedInterfaceVersions(t.Context()))
+}
+
+func TestCheckDiskSize(t *testing.T) {
+	img := &Item{
+		Metadata: ItemMetadata{Name: "ubuntu-server-noble-24.04"},
+		Status:   ImageMetadataStatus{VirtualSize: 3758096384},
+	}
+	require.NoError(t, checkDiskSize("40Gi", img))
+	require.NoError(t, checkDiskSize("3584Mi", img))
+	require.EqualError(t, checkDiskSize("2Gi", img), "disk size 2Gi is smaller than the virtual size 3584Mi of image ubuntu-server-noble-24.04")
+	require.ErrorContains(t, checkDiskSize("lots", img), "invalid disk size lots")
+	require.NoError(t, checkDiskSize("1Gi", &Item{}))
+}
+
+func TestCheckMACAddresses(t *testing.T) {
+	vm := &kubevirtv1.VirtualMachine{
+		ObjectMeta: v1.ObjectMeta{Name: "garm-runner-1", Namespace: "garm"},
+		Spec:       kubevirtv1.VirtualMachineSpec{Template: &kubevirtv1.VirtualMachineInstanceTemplateSpec{}},
+	}
+	vm.Spec.Template.Spec.Domain.Devices.Interfaces = []kubevirtv1.Interface{{Name: "nic-0", MacAddress: "02:00:00:00:01:00"}}
+	h := &HarvesterProvider{
+		GarmConfig:      &config.Config{Namespace: "garm"},
+		HarvesterClient: harvfake.NewSimpleClientset(vm),
+	}
+
+	nics := []config.NetworkSpec{{NetworkName: "ci/vlan-100", MACAddress: "02:00:00:00:01:00"}}
+	require.NoError(t, h.checkMACAddresses(t.Context(), "garm-runner-1", nics))
+	require.NoError(t, h.checkMACAddresses(t.Context(), "garm-runner-2", []config.NetworkSpec{{}}))
+	err := h.checkMACAddresses(t.Context(), "garm-runner-2", nics)
+	require.ErrorIs(t, err, garmErrors.ErrBadRequest)
+	require.ErrorContains(t, err, "mac_address 02:00:00:00:01:00 is already used by garm-runner-1")
+}
+
+func TestCheckImageReady(t *testing.T) {
+	imported := func(status string) []ImageCondition {
+		return []ImageCondition{{Type: "Initialized", Status: "True"}, {Type: "Imported", Status: status, Message: "downloading"}}
+	}
+	ready := &Item{
+		Metadata: ItemMetadata{Name: "ubuntu"},
+		Status:   ImageMetadataStatus{StorageClassName: "longhorn-ubuntu", Progress: 100, Conditions: imported("True")},
+	}
+	require.NoError(t, checkImageReady(ready))
+	require.EqualError(t, checkImageReady(&Item{
+		Metadata: ItemMetadata{Name: "ubuntu"},
+		Status:   ImageMetadataStatus{Progress: 42, Conditions: imported("Unknown")},
+	}), "image ubuntu is not imported yet (42%): downloading")
+	require.EqualError(t, checkImageReady(&Item{Metadata: ItemMetadata{Name: "ubuntu"}}), "image ubuntu is not imported yet (0%)")
+	require.EqualError(t, checkImageReady(&Item{
+		Metadata: ItemMetadata{Name: "ubuntu"},
+		Status:   ImageMetadataStatus{Progress: 100, Conditions: imported("True")},
+	}), "image ubuntu has no storage class")
+}
+
+func TestValidatePoolInfoImage(t *testing.T) {
+	images := `{"items":[
+		{"metadata":{"name":"ready"},"status":{"storageClassName":"longhorn-ready","progress":100,"conditions":[{"type":"Imported","status":"True"}]}},
+		{"metadata":{"name":"importing"},"status":{"progress":42,"conditions":[{"type":"Imported","status":"Unknown"}]}}
+	]}`
+	tests := []struct {
+		name    string
+		image   string
+		status  int
+		body    string
+		errIs   error
+		errNot  error
+		errMsg  string
+		noError bool
+	}{
+		{name: "ready", image: "public/ready", status: http.StatusOK, body: images, noError: true},
+		{name: "missing", image: "public/missing", status: http.StatusOK, body: images, errIs: garmErrors.ErrBadRequest, errMsg: "no such VirtualMachineImage"},
+		{name: "importing", image: "public/importing", status: http.StatusOK, body: images, errIs: garmErrors.ErrBadRequest, errMsg: "image importing is not imported yet (42%)"},
+		{
+			name: "forbidden", image: "public/ready", status: http.StatusForbidden,
+			body:  `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Forbidden","code":403}`,
+			errIs: garmErrors.ErrUnauthorized, errNot: garmErrors.ErrBadRequest,
+		},
+		{
+			name: "unavailable", image: "public/ready", status: http.StatusServiceUnavailable,
+			body:   `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"ServiceUnavailable","code":503}`,
+			errNot: garmErrors.ErrBadRequest, errMsg: "failed to query storage class",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+			kubeClient, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+			require.NoError(t, err)
+			h := &HarvesterProvider{GarmConfig: &config.Config{Namespace: "garm"}, KubeClient: kubeClient}
+
+			err = h.ValidatePoolInfo(t.Context(), tt.image, "small", "", "")
+			if tt.noError {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			if tt.errIs != nil {
+				require.ErrorIs(t, err, tt.errIs)
+			}
+			if tt.errNot != nil {
+				require.NotErrorIs(t, err, tt.errNot)
+			}
+			if tt.errMsg != "" {
+				require.ErrorContains(t, err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestDeleteVMKeepsErrorType(t *testing.T) {
+	vm := gcVM("garm-runner", time.Now(), kubevirtv1.RunStrategyRerunOnFailure, "garm-runner-cache")
+	kubeClient := k8sfake.NewSimpleClientset()
+	kubeClient.PrependReactor("get", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(kubeschema.GroupResource{Resource: "persistentvolumeclaims"}, "garm-runner-cache", errors.New("denied"))
+	})
+	h := &HarvesterProvider{
+		GarmConfig:      &config.Config{Namespace: gcNamespace},
+		KubeClient:      kubeClient,
+		HarvesterClient: harvfake.NewSimpleClientset(vm),
+	}
+
+	_, err := h.deleteVM(t.Context(), vm)
+	require.ErrorIs(t, err, garmErrors.ErrUnauthorized)
+	require.ErrorContains(t, err, "failed to find pvcs of garm-runner: failed to get pvc garm-runner-cache")
+}
+
+func TestRemoveAllInstances(t *testing.T) {
+	runners := func() []runtime.Object {
+		var objects []runtime.Object
+		for _, name := range []string{"runner-1", "runner-2", "runner-3", "runner-4"} {
+			objects = append(objects, gcVM(name, time.Now(), kubevirtv1.RunStrategyRerunOnFailure))
+		}
+		other := gcVM("other", time.Now(), kubevirtv1.RunStrategyRerunOnFailure)
+		other.Labels[fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, controllerIdConst)] = "other"
+		return append(objects, other)
+	}
+	remaining := func(t *testing.T, h *HarvesterProvider) []string {
+		vms, err := h.HarvesterClient.KubevirtV1().VirtualMachines(gcNamespace).List(t.Context(), v1.ListOptions{})
+		require.NoError(t, err)
+		var names []string
+		for _, vm := range vms.Items {
+			names = append(names, vm.Name)
+		}
+		return names
+	}
+
+	t.Run("failures are joined", func(t *testing.T) {
+		harvesterClient := harvfake.NewSimpleClientset(runners()...)
+		harvesterClient.PrependReactor("delete", "virtualmachines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			switch name := action.(k8stesting.DeleteAction).GetName(); name {
+			case "runner-1", "runner-3":
+				return true, nil, apierrors.NewInternalError(fmt.Errorf("%s is stuck", name))
+			}
+			return false, nil, nil
+		})
+		h := &HarvesterProvider{
+			GarmConfig:      &config.Config{Namespace: gcNamespace, DeleteConcurrency: 2},
+			KubeClient:      k8sfake.NewSimpleClientset(),
+			HarvesterClient: harvesterClient,
+			ControllerID:    gcController,
+		}
+
+		err := h.RemoveAllInstances(t.Context())
+		require.ErrorContains(t, err, "failed to remove 2 of 4 instances")
+		require.ErrorContains(t, err, "failed to delete instance runner-1")
+		require.ErrorContains(t, err, "runner-1 is stuck")
+		require.ErrorContains(t, err, "failed to delete instance runner-3")
+		require.ErrorContains(t, err, "runner-3 is stuck")
+		require.ElementsMatch(t, []string{"runner-1", "runner-3", "other"}, remaining(t, h))
+	})
+
+	t.Run("delete wait", func(t *testing.T) {
+		vmi := &kubevirtv1.VirtualMachineInstance{ObjectMeta: gcMeta("runner-2", time.Now())}
+		h := &HarvesterProvider{
+			GarmConfig: &config.Config{
+				Namespace:     gcNamespace,
+				DeleteWait:    true,
+				DeleteTimeout: config.Duration(time.Millisecond),
+			},
+			KubeClient:      k8sfake.NewSimpleClientset(),
+			HarvesterClient: harvfake.NewSimpleClientset(append(runners(), vmi)...),
+			ControllerID:    gcController,
+		}
+
+		err := h.RemoveAllInstances(t.Context())
+		require.ErrorIs(t, err, garmErrors.ErrTimeout)
+		require.ErrorContains(t, err, "failed to remove 1 of 4 instances")
+		require.ErrorContains(t, err, "instance runner-2 was not torn down within 1ms, left: vmi runner-2")
+		require.Equal(t, []string{"other"}, remaining(t, h))
+	})
+}
@@ -0,0 +1,197 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"garm-provider-harvester/pkg/utils"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+// rollbackTimeout bounds the cleanup of a failed CreateInstance. Cleanup runs on
+// a context detached from the caller, so a create cancelled by SIGTERM still
+// removes what it made.
+const rollbackTimeout = 2 * time.Minute
+
+// createdResources tracks what CreateInstance made in the cluster. The boot PVC
+// is not tracked on its own: Harvester creates it from the VM's volume claim
+// templates, so it is found through the VM volumes.
+type createdResources struct {
+	vm     *kubevirtv1.VirtualMachine
+	secret *corev1.Secret
+}
+
+// createRunner creates the VM and its cloud-init secret, tearing down whatever
+// was created if any step fails or the context is cancelled on the way.
+func (h *HarvesterProvider) createRunner(ctx context.Context, vm *kubevirtv1.VirtualMachine, secret *corev1.Secret) (*kubevirtv1.VirtualMachine, error) {
+	created := &createdResources{}
+
+	res, err := h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).Create(ctx, vm, v1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		res, err = h.adoptVM(ctx, vm)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			return nil, apiError(err, "failed to create VM %s", vm.Name)
+		}
+		// The request may have reached the API server before the context was
+		// cancelled, in which case the VM exists without us knowing its UID.
+		created.vm = vm
+		return nil, h.rollback(ctx, created, apiError(err, "failed to create VM %s", vm.Name))
+	}
+	created.vm = res
+	slog.Info(fmt.Sprintf("%s: instance created", res.Name))
+
+	if secret != nil {
+		secret.OwnerReferences = []v1.OwnerReference{
+			{
+				APIVersion: vm.APIVersion,
+				Kind:       vm.Kind,
+				Name:       res.Name,
+				UID:        res.UID,
+			},
+		}
+		_, err := h.KubeClient.CoreV1().Secrets(h.GarmConfig.Namespace).Create(ctx, secret, v1.CreateOptions{})
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			if ctx.Err() != nil {
+				created.secret = secret
+			}
+			return nil, h.rollback(ctx, created, apiError(err, "failed to create cloud-init secret %s", secret.Name))
+		}
+		created.secret = secret
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, h.rollback(ctx, created, fmt.Errorf("create of %s interrupted: %w", res.Name, err))
+	}
+	return res, nil
+}
+
+// adoptVM returns the existing VM a retried create collided with. GARM retries
+// creates that timed out, so a VM with the requested name, controller ID and
+// pool ID is the runner an earlier attempt made. VMs labelled for another
+// controller or pool are never adopted.
+func (h *HarvesterProvider) adoptVM(ctx context.Context, vm *kubevirtv1.VirtualMachine) (*kubevirtv1.VirtualMachine, error) {
+	existing, err := h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).Get(ctx, vm.Name, v1.GetOptions{})
+	if err != nil {
+		return nil, apiError(err, "VM %s already exists but could not be fetched", vm.Name)
+	}
+	for _, key := range []string{controllerIdConst, poolIdConst} {
+		label := fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, key)
+		if existing.Labels[label] != vm.Labels[label] {
+			return nil, fmt.Errorf("refusing to adopt existing VM %s: label %s is %q, expected %q: %w", vm.Name, label, existing.Labels[label], vm.Labels[label], garmErrors.ErrDuplicateEntity)
+		}
+	}
+	if existing.DeletionTimestamp != nil {
+		return nil, fmt.Errorf("refusing to adopt existing VM %s: VM is being deleted: %w", vm.Name, garmErrors.ErrDuplicateEntity)
+	}
+	slog.Info(fmt.Sprintf("%s: adopting existing instance", vm.Name))
+	return existing, nil
+}
+
+// rollback removes the resources of a failed create and returns cause annotated
+// with what was rolled back and whatever could not be removed.
+func (h *HarvesterProvider) rollback(ctx context.Context, created *createdResources, cause error) error {
+	cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+
+	var (
+		rolledBack []string
+		errs       []error
+	)
+	if created.secret != nil {
+		err := h.KubeClient.CoreV1().Secrets(h.GarmConfig.Namespace).Delete(cleanupCtx, created.secret.Name, v1.DeleteOptions{})
+		switch {
+		case err == nil:
+			rolledBack = append(rolledBack, fmt.Sprintf("secret %s", created.secret.Name))
+		case !apierrors.IsNotFound(err):
+			errs = append(errs, fmt.Errorf("failed to remove secret %s: %w", created.secret.Name, err))
+		}
+	}
+	if created.vm != nil {
+		removed, err := h.rollbackVM(cleanupCtx, created.vm)
+		rolledBack = append(rolledBack, removed...)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	slog.Info(fmt.Sprintf("rolled back failed create: %s", strings.Join(rolledBack, ", ")))
+	if len(errs) > 0 {
+		return fmt.Errorf("%w; rolled back [%s]; rollback incomplete: %w", cause, strings.Join(rolledBack, ", "), errors.Join(errs...))
+	}
+	return fmt.Errorf("%w; rolled back [%s]", cause, strings.Join(rolledBack, ", "))
+}
+
+// rollbackVM deletes a VM made by a failed create along with its PVCs. A VM
+// without a UID comes from an interrupted create call; it is only removed if it
+// carries this controller's label.
+func (h *HarvesterProvider) rollbackVM(ctx context.Context, vm *kubevirtv1.VirtualMachine) ([]string, error) {
+	if vm.UID == "" {
+		existing, err := h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).Get(ctx, vm.Name, v1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("failed to get VM %s: %w", vm.Name, err)
+		}
+		if existing.Labels[fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, controllerIdConst)] != h.ControllerID {
+			return nil, nil
+		}
+		vm = existing
+	}
+
+	var removed []string
+	pvcsToRemove, err := h.vpcsToRemove(ctx, vm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find pvcs of VM %s: %w", vm.Name, err)
+	}
+	// The volumes of a runner that never ran hold no data worth keeping, so
+	// the ones kept on deletion are removed too.
+	templates, err := templateAutoDelete(vm)
+	if err != nil {
+		return nil, err
+	}
+	for pvc := range templates {
+		if !slices.Contains(pvcsToRemove, pvc) {
+			pvcsToRemove = append(pvcsToRemove, pvc)
+		}
+	}
+
+	propagationPolicy := v1.DeletePropagationForeground
+	deleteOptions := v1.DeleteOptions{
+		PropagationPolicy: &propagationPolicy,
+		Preconditions:     v1.NewUIDPreconditions(string(vm.UID)),
+	}
+	err = h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).Delete(ctx, vm.Name, deleteOptions)
+	switch {
+	case err == nil:
+		removed = append(removed, fmt.Sprintf("vm %s", vm.Name))
+	case !apierrors.IsNotFound(err):
+		return removed, fmt.Errorf("failed to remove VM %s: %w", vm.Name, err)
+	}
+
+	var errs []error
+	for _, pvc := range pvcsToRemove {
+		err := h.KubeClient.CoreV1().PersistentVolumeClaims(h.GarmConfig.Namespace).Delete(ctx, pvc, v1.DeleteOptions{PropagationPolicy: &propagationPolicy})
+		switch {
+		case err == nil:
+			removed = append(removed, fmt.Sprintf("pvc %s", pvc))
+		case !apierrors.IsNotFound(err):
+			errs = append(errs, fmt.Errorf("failed to remove pvc %s: %w", pvc, err))
+		}
+	}
+	return removed, errors.Join(errs...)
+}
@@ -0,0 +1,369 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"garm-provider-harvester/pkg/config"
+	"garm-provider-harvester/pkg/utils"
+	"testing"
+	"time"
+
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
+	"github.com/harvester/harvester/pkg/builder"
+	harvfake "github.com/harvester/harvester/pkg/generated/clientset/versioned/fake"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeschema "k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+const (
+	rollbackNamespace  = "garm"
+	rollbackController = "controller"
+	rollbackPool       = "pool"
+)
+
+// rollbackRunner returns the VM CreateInstance would build for a runner, with a
+// boot disk and a cache disk Harvester creates from volume claim templates.
+func rollbackRunner(t *testing.T, controller, pool string) *kubevirtv1.VirtualMachine {
+	storageClass := "longhorn"
+	option := func(autoDelete string) *builder.PersistentVolumeClaimOption {
+		return &builder.PersistentVolumeClaimOption{
+			VolumeMode:       corev1.PersistentVolumeBlock,
+			AccessMode:       corev1.ReadWriteMany,
+			StorageClassName: &storageClass,
+			Annotations:      map[string]string{autoDeleteAnnotation: autoDelete},
+		}
+	}
+	vm, err := builder.NewVMBuilder("garm-provider").Namespace(rollbackNamespace).Name("garm-runner").
+		PVCDisk("rootdisk", "virtio", false, false, 1, "10Gi", "garm-runner-rootdisk", option("true")).
+		PVCDisk("cache", "virtio", false, false, 0, "10Gi", "garm-runner-cache", option("false")).
+		Labels(map[string]string{
+			fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, controllerIdConst): controller,
+			fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, poolIdConst):       pool,
+		}).
+		VM()
+	require.NoError(t, err)
+	vm.Kind = kubevirtv1.VirtualMachineGroupVersionKind.Kind
+	vm.APIVersion = kubevirtv1.GroupVersion.String()
+	return vm
+}
+
+func rollbackSecret() *corev1.Secret {
+	return &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: "garm-runner-cloudinit", Namespace: rollbackNamespace}}
+}
+
+func rollbackPVC(name string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{ObjectMeta: v1.ObjectMeta{Name: name, Namespace: rollbackNamespace}}
+}
+
+// newRollbackProvider returns a provider on fake clients that hands out a UID
+// to every VM it creates, as the API server does.
+func newRollbackProvider(harvesterObjects []runtime.Object, kubeObjects ...runtime.Object) *HarvesterProvider {
+	harvesterClient := harvfake.NewSimpleClientset(harvesterObjects...)
+	harvesterClient.PrependReactor("create", "virtualmachines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		vm := action.(k8stesting.CreateAction).GetObject().(*kubevirtv1.VirtualMachine)
+		vm.UID = types.UID(vm.Name + "-uid")
+		return false, nil, nil
+	})
+	return &HarvesterProvider{
+		GarmConfig:      &config.Config{Namespace: rollbackNamespace},
+		KubeClient:      k8sfake.NewSimpleClientset(kubeObjects...),
+		HarvesterClient: harvesterClient,
+		ControllerID:    rollbackController,
+	}
+}
+
+// rollbackDeletes lists the deletes a fake client received, with the UID
+// precondition they carried.
+func rollbackDeletes(actions []k8stesting.Action) []string {
+	var deletes []string
+	for _, action := range actions {
+		deleteAction, ok := action.(k8stesting.DeleteAction)
+		if !ok {
+			continue
+		}
+		entry := fmt.Sprintf("%s/%s", deleteAction.GetResource().Resource, deleteAction.GetName())
+		if preconditions := deleteAction.GetDeleteOptions().Preconditions; preconditions != nil && preconditions.UID != nil {
+			entry += fmt.Sprintf(" uid=%s", *preconditions.UID)
+		}
+		deletes = append(deletes, entry)
+	}
+	return deletes
+}
+
+func TestCreateRunner(t *testing.T) {
+	boom := errors.New("boom")
+	tests := []struct {
+		name string
+		// pvcs are the PVCs Harvester made from the volume claim templates.
+		pvcs []string
+		// setup installs the reactors making the create fail. It may cancel
+		// the context of the create.
+		setup           func(h *HarvesterProvider, cancel context.CancelFunc)
+		errContains     []string
+		errIs           error
+		harvesterDelete []string
+		kubeDelete      []string
+		remaining       []string
+	}{
+		{
+			name:      "created",
+			pvcs:      []string{"garm-runner-rootdisk", "garm-runner-cache"},
+			remaining: []string{"vm garm-runner", "secret garm-runner-cloudinit", "pvc garm-runner-cache", "pvc garm-runner-rootdisk"},
+		},
+		{
+			name: "vm create fails",
+			setup: func(h *HarvesterProvider, cancel context.CancelFunc) {
+				h.HarvesterClient.(*harvfake.Clientset).PrependReactor("create", "virtualmachines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, apierrors.NewForbidden(kubeschema.GroupResource{Resource: "virtualmachines"}, "garm-runner", boom)
+				})
+			},
+			errContains: []string{"failed to create VM garm-runner"},
+			errIs:       garmErrors.ErrUnauthorized,
+		},
+		{
+			name: "secret create fails",
+			pvcs: []string{"garm-runner-rootdisk", "garm-runner-cache"},
+			setup: func(h *HarvesterProvider, cancel context.CancelFunc) {
+				h.KubeClient.(*k8sfake.Clientset).PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, boom
+				})
+			},
+			errContains: []string{
+				"failed to create cloud-init secret garm-runner-cloudinit: boom",
+				"rolled back [vm garm-runner, pvc garm-runner-rootdisk, pvc garm-runner-cache]",
+			},
+			harvesterDelete: []string{"virtualmachines/garm-runner uid=garm-runner-uid"},
+			kubeDelete:      []string{"persistentvolumeclaims/garm-runner-rootdisk", "persistentvolumeclaims/garm-runner-cache"},
+		},
+		{
+			name: "boot pvc never created",
+			pvcs: []string{"garm-runner-cache"},
+			setup: func(h *HarvesterProvider, cancel context.CancelFunc) {
+				h.KubeClient.(*k8sfake.Clientset).PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, boom
+				})
+			},
+			errContains:     []string{"rolled back [vm garm-runner, pvc garm-runner-cache]"},
+			harvesterDelete: []string{"virtualmachines/garm-runner uid=garm-runner-uid"},
+			kubeDelete:      []string{"persistentvolumeclaims/garm-runner-rootdisk", "persistentvolumeclaims/garm-runner-cache"},
+		},
+		{
+			name: "pvc removal fails",
+			pvcs: []string{"garm-runner-rootdisk", "garm-runner-cache"},
+			setup: func(h *HarvesterProvider, cancel context.CancelFunc) {
+				kubeClient := h.KubeClient.(*k8sfake.Clientset)
+				kubeClient.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, boom
+				})
+				kubeClient.PrependReactor("delete", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					if action.(k8stesting.DeleteAction).GetName() == "garm-runner-rootdisk" {
+						return true, nil, boom
+					}
+					return false, nil, nil
+				})
+			},
+			errContains: []string{
+				"rolled back [vm garm-runner, pvc garm-runner-cache]",
+				"rollback incomplete: failed to remove pvc garm-runner-rootdisk: boom",
+			},
+			harvesterDelete: []string{"virtualmachines/garm-runner uid=garm-runner-uid"},
+			kubeDelete:      []string{"persistentvolumeclaims/garm-runner-rootdisk", "persistentvolumeclaims/garm-runner-cache"},
+			remaining:       []string{"pvc garm-runner-rootdisk"},
+		},
+		{
+			name: "cancelled after the secret",
+			pvcs: []string{"garm-runner-rootdisk"},
+			setup: func(h *HarvesterProvider, cancel context.CancelFunc) {
+				h.KubeClient.(*k8sfake.Clientset).PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					cancel()
+					return false, nil, nil
+				})
+			},
+			errContains: []string{
+				"create of garm-runner interrupted: context canceled",
+				"rolled back [secret garm-runner-cloudinit, vm garm-runner, pvc garm-runner-rootdisk]",
+			},
+			harvesterDelete: []string{"virtualmachines/garm-runner uid=garm-runner-uid"},
+			kubeDelete:      []string{"secrets/garm-runner-cloudinit", "persistentvolumeclaims/garm-runner-rootdisk", "persistentvolumeclaims/garm-runner-cache"},
+		},
+		{
+			name: "cancelled during the vm create",
+			pvcs: []string{"garm-runner-rootdisk"},
+			setup: func(h *HarvesterProvider, cancel context.CancelFunc) {
+				harvesterClient := h.HarvesterClient.(*harvfake.Clientset)
+				harvesterClient.PrependReactor("create", "virtualmachines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					// The API server made the VM, but the caller gave up
+					// before the response arrived.
+					vm := action.(k8stesting.CreateAction).GetObject().(*kubevirtv1.VirtualMachine).DeepCopy()
+					vm.UID = "garm-runner-uid"
+					cancel()
+					require.NoError(t, harvesterClient.Tracker().Create(action.GetResource(), vm, vm.Namespace))
+					return true, nil, context.Canceled
+				})
+			},
+			errContains: []string{
+				"failed to create VM garm-runner",
+				"rolled back [vm garm-runner, pvc garm-runner-rootdisk]",
+			},
+			harvesterDelete: []string{"virtualmachines/garm-runner uid=garm-runner-uid"},
+			kubeDelete:      []string{"persistentvolumeclaims/garm-runner-rootdisk", "persistentvolumeclaims/garm-runner-cache"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pvcs []runtime.Object
+			for _, pvc := range tt.pvcs {
+				pvcs = append(pvcs, rollbackPVC(pvc))
+			}
+			h := newRollbackProvider(nil, pvcs...)
+			ctx, cancel := context.WithCancel(t.Context())
+			defer cancel()
+			if tt.setup != nil {
+				tt.setup(h, cancel)
+			}
+
+			res, err := h.createRunner(ctx, rollbackRunner(t, rollbackController, rollbackPool), rollbackSecret())
+			if tt.errContains == nil {
+				require.NoError(t, err)
+				require.Equal(t, "garm-runner", res.Name)
+			} else {
+				require.Nil(t, res)
+				for _, msg := range tt.errContains {
+					require.ErrorContains(t, err, msg)
+				}
+			}
+			if tt.errIs != nil {
+				require.ErrorIs(t, err, tt.errIs)
+			}
+
+			require.Equal(t, tt.harvesterDelete, rollbackDeletes(h.HarvesterClient.(*harvfake.Clientset).Actions()))
+			require.Equal(t, tt.kubeDelete, rollbackDeletes(h.KubeClient.(*k8sfake.Clientset).Actions()))
+			require.ElementsMatch(t, tt.remaining, rollbackRemaining(t, h))
+		})
+	}
+}
+
+// rollbackRemaining lists the VMs, secrets and PVCs left in the namespace.
+func rollbackRemaining(t *testing.T, h *HarvesterProvider) []string {
+	var names []string
+	vms, err := h.HarvesterClient.KubevirtV1().VirtualMachines(rollbackNamespace).List(t.Context(), v1.ListOptions{})
+	require.NoError(t, err)
+	for _, vm := range vms.Items {
+		names = append(names, "vm "+vm.Name)
+	}
+	secrets, err := h.KubeClient.CoreV1().Secrets(rollbackNamespace).List(t.Context(), v1.ListOptions{})
+	require.NoError(t, err)
+	for _, secret := range secrets.Items {
+		names = append(names, "secret "+secret.Name)
+	}
+	pvcs, err := h.KubeClient.CoreV1().PersistentVolumeClaims(rollbackNamespace).List(t.Context(), v1.ListOptions{})
+	require.NoError(t, err)
+	for _, pvc := range pvcs.Items {
+		names = append(names, "pvc "+pvc.Name)
+	}
+	return names
+}
+
+func TestRollbackVM(t *testing.T) {
+	t.Run("interrupted create of another controller", func(t *testing.T) {
+		// A VM without a UID comes from a create that may never have
+		// reached the API server; the VM found under its name is not ours.
+		existing := rollbackRunner(t, "other", rollbackPool)
+		existing.UID = "other-uid"
+		h := newRollbackProvider([]runtime.Object{existing}, rollbackPVC("garm-runner-rootdisk"))
+
+		removed, err := h.rollbackVM(t.Context(), rollbackRunner(t, rollbackController, rollbackPool))
+		require.NoError(t, err)
+		require.Empty(t, removed)
+		require.Empty(t, rollbackDeletes(h.HarvesterClient.(*harvfake.Clientset).Actions()))
+		require.ElementsMatch(t, []string{"vm garm-runner", "pvc garm-runner-rootdisk"}, rollbackRemaining(t, h))
+	})
+
+	t.Run("interrupted create that never arrived", func(t *testing.T) {
+		h := newRollbackProvider(nil)
+		removed, err := h.rollbackVM(t.Context(), rollbackRunner(t, rollbackController, rollbackPool))
+		require.NoError(t, err)
+		require.Empty(t, removed)
+	})
+
+	t.Run("vm delete fails", func(t *testing.T) {
+		vm := rollbackRunner(t, rollbackController, rollbackPool)
+		vm.UID = "garm-runner-uid"
+		h := newRollbackProvider([]runtime.Object{vm}, rollbackPVC("garm-runner-rootdisk"))
+		h.HarvesterClient.(*harvfake.Clientset).PrependReactor("delete", "virtualmachines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewConflict(kubeschema.GroupResource{Resource: "virtualmachines"}, "garm-runner", errors.New("uid mismatch"))
+		})
+
+		removed, err := h.rollbackVM(t.Context(), vm)
+		require.ErrorContains(t, err, "failed to remove VM garm-runner")
+		require.Empty(t, removed)
+		// The PVCs stay while the VM using them is still there.
+		require.Empty(t, rollbackDeletes(h.KubeClient.(*k8sfake.Clientset).Actions()))
+	})
+}
+
+func TestAdoptVM(t *testing.T) {
+	deleting := rollbackRunner(t, rollbackController, rollbackPool)
+	deleting.DeletionTimestamp = &v1.Time{Time: time.Now()}
+	deleting.Finalizers = []string{"foregroundDeletion"}
+
+	tests := []struct {
+		name     string
+		existing *kubevirtv1.VirtualMachine
+		errMsg   string
+	}{
+		{
+			name:     "same owner",
+			existing: rollbackRunner(t, rollbackController, rollbackPool),
+		},
+		{
+			name:     "other controller",
+			existing: rollbackRunner(t, "other", rollbackPool),
+			errMsg:   `refusing to adopt existing VM garm-runner: label harvesterhci.io/controller-id is "other", expected "controller"`,
+		},
+		{
+			name:     "other pool",
+			existing: rollbackRunner(t, rollbackController, "other"),
+			errMsg:   `refusing to adopt existing VM garm-runner: label harvesterhci.io/pool-id is "other", expected "pool"`,
+		},
+		{
+			name:     "being deleted",
+			existing: deleting,
+			errMsg:   "refusing to adopt existing VM garm-runner: VM is being deleted",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.existing.UID = "existing-uid"
+			h := newRollbackProvider([]runtime.Object{tt.existing})
+
+			res, err := h.createRunner(t.Context(), rollbackRunner(t, rollbackController, rollbackPool), rollbackSecret())
+			if tt.errMsg != "" {
+				require.ErrorContains(t, err, tt.errMsg)
+				require.ErrorIs(t, err, garmErrors.ErrDuplicateEntity)
+				require.Nil(t, res)
+				// A VM that is not ours is never rolled back.
+				require.Empty(t, rollbackDeletes(h.HarvesterClient.(*harvfake.Clientset).Actions()))
+				require.ElementsMatch(t, []string{"vm garm-runner"}, rollbackRemaining(t, h))
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, types.UID("existing-uid"), res.UID)
+			require.ElementsMatch(t, []string{"vm garm-runner", "secret garm-runner-cloudinit"}, rollbackRemaining(t, h))
+			secret, err := h.KubeClient.CoreV1().Secrets(rollbackNamespace).Get(t.Context(), "garm-runner-cloudinit", v1.GetOptions{})
+			require.NoError(t, err)
+			require.Equal(t, types.UID("existing-uid"), secret.OwnerReferences[0].UID)
+		})
+	}
+}
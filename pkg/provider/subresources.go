@@ -0,0 +1,175 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/ptr"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+// pollInterval is how often the provider checks on a VM it is waiting for.
+var pollInterval = 5 * time.Second
+
+// conflictBackoff paces the retries of run strategy updates that hit a
+// resourceVersion conflict.
+var conflictBackoff = wait.Backoff{
+	Steps:    6,
+	Duration: 500 * time.Millisecond,
+	Factor:   2.0,
+	Jitter:   0.1,
+}
+
+// retryOnConflict runs fn until it succeeds or fails with anything but a
+// conflict. fn is expected to read the VM again on every attempt. Only
+// resourceVersion conflicts of updates may reach it: the start and stop
+// subresources also answer 409 for VMs in a state they do not accept, which
+// no retry fixes, so changeRunStrategy handles those itself.
+func retryOnConflict(ctx context.Context, fn func(ctx context.Context) error) error {
+	var lastErr error
+	err := wait.ExponentialBackoffWithContext(ctx, conflictBackoff, func(ctx context.Context) (bool, error) {
+		lastErr = fn(ctx)
+		switch {
+		case lastErr == nil:
+			return true, nil
+		case apierrors.IsConflict(lastErr):
+			return false, nil
+		default:
+			return false, lastErr
+		}
+	})
+	if wait.Interrupted(err) && lastErr != nil {
+		return lastErr
+	}
+	return err
+}
+
+// getVMAndVMI fetches a VM and, if it has one, its VMI.
+func (h *HarvesterProvider) getVMAndVMI(ctx context.Context, name string) (*kubevirtv1.VirtualMachine, *kubevirtv1.VirtualMachineInstance, error) {
+	vm, err := h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).Get(ctx, name, v1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	vmi, err := h.getVMI(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return vm, vmi, nil
+}
+
+// isStarted reports whether a VM is running, or will be started by KubeVirt
+// without being asked.
+func isStarted(vm *kubevirtv1.VirtualMachine, vmi *kubevirtv1.VirtualMachineInstance) bool {
+	if vmi != nil && vmi.DeletionTimestamp == nil && !vmi.IsFinal() {
+		return true
+	}
+	runStrategy, _ := vm.RunStrategy()
+	return vmi == nil && runStrategy == kubevirtv1.RunStrategyAlways
+}
+
+// isStopped reports whether a VM is powered off and will stay that way.
+func isStopped(vm *kubevirtv1.VirtualMachine, vmi *kubevirtv1.VirtualMachineInstance) bool {
+	runStrategy, _ := vm.RunStrategy()
+	switch runStrategy {
+	case kubevirtv1.RunStrategyAlways:
+		return false
+	case kubevirtv1.RunStrategyRerunOnFailure:
+		// KubeVirt restarts failed VMIs and recreates missing ones.
+		return vmi != nil && vmi.Status.Phase == kubevirtv1.Succeeded
+	default:
+		return vmi == nil || vmi.IsFinal()
+	}
+}
+
+// isStopping reports whether a VM was asked to stop and its guest is still
+// shutting down.
+func isStopping(vm *kubevirtv1.VirtualMachine, vmi *kubevirtv1.VirtualMachineInstance) bool {
+	runStrategy, _ := vm.RunStrategy()
+	return runStrategy == kubevirtv1.RunStrategyHalted && vmi != nil
+}
+
+// changeRunStrategy starts or stops a VM through its KubeVirt subresource. When
+// the subresource API is not served, or it refuses the VM in its current state,
+// such as stopping a RerunOnFailure VM that has no VMI, it falls back to
+// updating the run strategy of the VM and reports that it did.
+func (h *HarvesterProvider) changeRunStrategy(ctx context.Context, vm *kubevirtv1.VirtualMachine, subresource string, opts any, runStrategy kubevirtv1.VirtualMachineRunStrategy) (bool, error) {
+	err := h.vmSubresource(ctx, vm.Name, subresource, opts)
+	switch {
+	case err == nil:
+		return false, nil
+	case apierrors.IsConflict(err):
+		slog.Debug(fmt.Sprintf("%s subresource refused %s, updating run strategy: %s", subresource, vm.Name, err))
+	case apierrors.IsNotFound(err) || apierrors.IsMethodNotSupported(err):
+		slog.Debug(fmt.Sprintf("%s subresource unavailable for %s, updating run strategy: %s", subresource, vm.Name, err))
+	default:
+		return false, err
+	}
+	vmCopy := vm.DeepCopy()
+	vmCopy.Spec.Running = nil
+	vmCopy.Spec.RunStrategy = &runStrategy
+	_, err = h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).Update(ctx, vmCopy, v1.UpdateOptions{})
+	return err == nil, err
+}
+
+// killVMI deletes the VMI of a VM without a grace period, which powers the
+// guest off at once. A VM halted through its run strategy is shut down with
+// the termination grace period of its VMI, too slow for a forced stop.
+func (h *HarvesterProvider) killVMI(ctx context.Context, name string) error {
+	err := h.HarvesterClient.KubevirtV1().VirtualMachineInstances(h.GarmConfig.Namespace).Delete(ctx, name, v1.DeleteOptions{GracePeriodSeconds: ptr.To(int64(0))})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// vmSubresource calls a subresource of a VM, such as stop or restart, through the
+// subresources.kubevirt.io API. opts is the matching KubeVirt options struct.
+func (h *HarvesterProvider) vmSubresource(ctx context.Context, name string, subresource string, opts any) error {
+	return h.subresource(ctx, "virtualmachines", name, subresource, opts)
+}
+
+// vmiSubresource calls a subresource of a VMI, such as pause or unpause.
+func (h *HarvesterProvider) vmiSubresource(ctx context.Context, name string, subresource string, opts any) error {
+	return h.subresource(ctx, "virtualmachineinstances", name, subresource, opts)
+}
+
+func (h *HarvesterProvider) subresource(ctx context.Context, resource string, name string, subresource string, opts any) error {
+	body, err := json.Marshal(opts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s options: %w", subresource, err)
+	}
+	return h.KubeVirtSubresourceClient.Put().
+		Namespace(h.GarmConfig.Namespace).
+		Resource(resource).
+		Name(name).
+		SubResource(subresource).
+		Body(body).
+		Do(ctx).
+		Error()
+}
+
+// waitForVMIGone waits until the VMI of a VM is deleted, which is when the
+// guest has powered off.
+func (h *HarvesterProvider) waitForVMIGone(ctx context.Context, name string, timeout time.Duration) error {
+	err := wait.PollUntilContextTimeout(ctx, pollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		vmi, err := h.getVMI(ctx, name)
+		if err != nil {
+			return false, err
+		}
+		return vmi == nil, nil
+	})
+	if err != nil {
+		if wait.Interrupted(err) {
+			return fmt.Errorf("instance %s did not power off within %s: %w", name, timeout, garmErrors.ErrTimeout)
+		}
+		return apiError(err, "failed to wait for instance %s to power off", name)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package provider
+
+import (
+	"garm-provider-harvester/pkg/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
+	harvfake "github.com/harvester/harvester/pkg/generated/clientset/versioned/fake"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeschema "k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+func TestPowerState(t *testing.T) {
+	vmWith := func(runStrategy kubevirtv1.VirtualMachineRunStrategy) *kubevirtv1.VirtualMachine {
+		return &kubevirtv1.VirtualMachine{Spec: kubevirtv1.VirtualMachineSpec{RunStrategy: &runStrategy}}
+	}
+	vmiIn := func(phase kubevirtv1.VirtualMachineInstancePhase) *kubevirtv1.VirtualMachineInstance {
+		return &kubevirtv1.VirtualMachineInstance{Status: kubevirtv1.VirtualMachineInstanceStatus{Phase: phase}}
+	}
+
+	tests := []struct {
+		name     string
+		vm       *kubevirtv1.VirtualMachine
+		vmi      *kubevirtv1.VirtualMachineInstance
+		started  bool
+		stopped  bool
+		stopping bool
+	}{
+		{
+			name:    "running",
+			vm:      vmWith(kubevirtv1.RunStrategyRerunOnFailure),
+			vmi:     vmiIn(kubevirtv1.Running),
+			started: true,
+		},
+		{
+			name:    "starting",
+			vm:      vmWith(kubevirtv1.RunStrategyAlways),
+			started: true,
+		},
+		{
+			name:    "halted",
+			vm:      vmWith(kubevirtv1.RunStrategyHalted),
+			stopped: true,
+		},
+		{
+			name:     "shutting down",
+			vm:       vmWith(kubevirtv1.RunStrategyHalted),
+			vmi:      vmiIn(kubevirtv1.Running),
+			started:  true,
+			stopping: true,
+		},
+		{
+			name:    "guest shut down",
+			vm:      vmWith(kubevirtv1.RunStrategyRerunOnFailure),
+			vmi:     vmiIn(kubevirtv1.Succeeded),
+			stopped: true,
+		},
+		{
+			name: "waiting for rerun",
+			vm:   vmWith(kubevirtv1.RunStrategyRerunOnFailure),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.started, isStarted(tt.vm, tt.vmi), "started")
+			require.Equal(t, tt.stopped, isStopped(tt.vm, tt.vmi), "stopped")
+			require.Equal(t, tt.stopping, isStopping(tt.vm, tt.vmi), "stopping")
+		})
+	}
+}
+
+func TestWaitForVMIGoneTimeout(t *testing.T) {
+	vmi := &kubevirtv1.VirtualMachineInstance{ObjectMeta: v1.ObjectMeta{Name: "garm-runner", Namespace: "garm"}}
+	h := &HarvesterProvider{
+		GarmConfig:      &config.Config{Namespace: "garm"},
+		HarvesterClient: harvfake.NewSimpleClientset(vmi),
+	}
+	err := h.waitForVMIGone(t.Context(), "garm-runner", time.Millisecond)
+	require.ErrorIs(t, err, garmErrors.ErrTimeout)
+}
+
+// newSubresourceClient returns a client for the subresources.kubevirt.io API
+// served by handler.
+func newSubresourceClient(t *testing.T, handler http.HandlerFunc) *rest.RESTClient {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	restClient, err := rest.RESTClientFor(&rest.Config{
+		Host:    server.URL,
+		APIPath: "/apis",
+		ContentConfig: rest.ContentConfig{
+			GroupVersion:         &kubeschema.GroupVersion{Group: "subresources.kubevirt.io", Version: "v1"},
+			NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		},
+	})
+	require.NoError(t, err)
+	return restClient
+}
+
+func TestStopRefusedBySubresource(t *testing.T) {
+	tests := []struct {
+		name  string
+		force bool
+		vmi   bool
+	}{
+		{name: "graceful without VMI"},
+		{name: "forced with VMI", force: true, vmi: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			restClient := newSubresourceClient(t, func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusConflict)
+				_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","message":"VM is not running","reason":"Conflict","code":409}`))
+			})
+
+			runStrategy := kubevirtv1.RunStrategyRerunOnFailure
+			vm := &kubevirtv1.VirtualMachine{
+				ObjectMeta: v1.ObjectMeta{Name: "garm-runner", Namespace: "garm"},
+				Spec:       kubevirtv1.VirtualMachineSpec{RunStrategy: &runStrategy},
+			}
+			harvesterClient := harvfake.NewSimpleClientset(vm)
+			if tt.vmi {
+				vmi := &kubevirtv1.VirtualMachineInstance{ObjectMeta: v1.ObjectMeta{Name: "garm-runner", Namespace: "garm"}}
+				vmi.Status.Phase = kubevirtv1.Running
+				require.NoError(t, harvesterClient.Tracker().Add(vmi))
+			}
+			h := &HarvesterProvider{
+				GarmConfig:                &config.Config{Namespace: "garm"},
+				KubeVirtSubresourceClient: restClient,
+				HarvesterClient:           harvesterClient,
+			}
+
+			require.NoError(t, h.Stop(t.Context(), "garm-runner", tt.force))
+			require.Equal(t, 1, calls)
+			stopped, err := h.HarvesterClient.KubevirtV1().VirtualMachines("garm").Get(t.Context(), "garm-runner", v1.GetOptions{})
+			require.NoError(t, err)
+			require.Equal(t, kubevirtv1.RunStrategyHalted, *stopped.Spec.RunStrategy)
+
+			var gracePeriods []int64
+			for _, action := range harvesterClient.Actions() {
+				if deleteAction, ok := action.(k8stesting.DeleteAction); ok && deleteAction.GetResource().Resource == "virtualmachineinstances" {
+					gracePeriods = append(gracePeriods, *deleteAction.GetDeleteOptions().GracePeriodSeconds)
+				}
+			}
+			if tt.vmi {
+				require.Equal(t, []int64{0}, gracePeriods)
+			} else {
+				require.Empty(t, gracePeriods)
+			}
+		})
+	}
+}
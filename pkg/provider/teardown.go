@@ -0,0 +1,91 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+// leftover describes a resource of a deleted runner that still exists.
+func leftover(kind string, meta v1.ObjectMeta) string {
+	if len(meta.Finalizers) == 0 {
+		return fmt.Sprintf("%s %s", kind, meta.Name)
+	}
+	return fmt.Sprintf("%s %s (finalizers: %s)", kind, meta.Name, strings.Join(meta.Finalizers, ", "))
+}
+
+// waitForTeardown waits until the VM, its VMI, its virt-launcher pod and the
+// removed PVCs are gone, so the capacity they held is free again. Whatever is
+// still there after the timeout is reported along with its finalizers.
+func (h *HarvesterProvider) waitForTeardown(ctx context.Context, vm *kubevirtv1.VirtualMachine, pvcs []string, timeout time.Duration) error {
+	var remaining []string
+	err := wait.PollUntilContextTimeout(ctx, pollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		var err error
+		remaining, err = h.teardownLeftovers(ctx, vm, pvcs)
+		if err != nil {
+			return false, err
+		}
+		return len(remaining) == 0, nil
+	})
+	if err != nil {
+		if wait.Interrupted(err) {
+			return fmt.Errorf("instance %s was not torn down within %s, left: %s: %w", vm.Name, timeout, strings.Join(remaining, "; "), garmErrors.ErrTimeout)
+		}
+		return apiError(err, "failed to wait for instance %s to be torn down", vm.Name)
+	}
+	return nil
+}
+
+// teardownLeftovers lists the resources of a deleted runner that still exist.
+func (h *HarvesterProvider) teardownLeftovers(ctx context.Context, vm *kubevirtv1.VirtualMachine, pvcs []string) ([]string, error) {
+	var remaining []string
+
+	existing, err := h.HarvesterClient.KubevirtV1().VirtualMachines(h.GarmConfig.Namespace).Get(ctx, vm.Name, v1.GetOptions{})
+	switch {
+	case err == nil:
+		if existing.UID == vm.UID {
+			remaining = append(remaining, leftover("vm", existing.ObjectMeta))
+		}
+	case !apierrors.IsNotFound(err):
+		return nil, err
+	}
+
+	vmi, err := h.getVMI(ctx, vm.Name)
+	if err != nil {
+		return nil, err
+	}
+	if vmi != nil {
+		remaining = append(remaining, leftover("vmi", vmi.ObjectMeta))
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{
+		kubevirtv1.AppLabel:                "virt-launcher",
+		kubevirtv1.VirtualMachineNameLabel: vm.Name,
+	})
+	pods, err := h.KubeClient.CoreV1().Pods(h.GarmConfig.Namespace).List(ctx, v1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods.Items {
+		remaining = append(remaining, leftover("pod", pod.ObjectMeta))
+	}
+
+	for _, name := range pvcs {
+		pvc, err := h.KubeClient.CoreV1().PersistentVolumeClaims(h.GarmConfig.Namespace).Get(ctx, name, v1.GetOptions{})
+		switch {
+		case err == nil:
+			remaining = append(remaining, leftover("pvc", pvc.ObjectMeta))
+		case !apierrors.IsNotFound(err):
+			return nil, err
+		}
+	}
+	return remaining, nil
+}
@@ -0,0 +1,162 @@
+package provider
+
+import (
+	"errors"
+	"garm-provider-harvester/pkg/config"
+	"testing"
+	"time"
+
+	garmErrors "github.com/cloudbase/garm-provider-common/errors"
+	harvfake "github.com/harvester/harvester/pkg/generated/clientset/versioned/fake"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeschema "k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+func TestLeftover(t *testing.T) {
+	require.Equal(t, "vmi garm-runner", leftover("vmi", v1.ObjectMeta{Name: "garm-runner"}))
+	require.Equal(t,
+		"pvc garm-runner-rootdisk (finalizers: kubernetes.io/pvc-protection, provisioner.storage.kubernetes.io/cloning-protection)",
+		leftover("pvc", v1.ObjectMeta{
+			Name:       "garm-runner-rootdisk",
+			Finalizers: []string{"kubernetes.io/pvc-protection", "provisioner.storage.kubernetes.io/cloning-protection"},
+		}))
+}
+
+func teardownMeta(name string) v1.ObjectMeta {
+	return v1.ObjectMeta{Name: name, Namespace: "garm"}
+}
+
+func teardownVM(uid string) *kubevirtv1.VirtualMachine {
+	vm := &kubevirtv1.VirtualMachine{ObjectMeta: teardownMeta("garm-runner")}
+	vm.UID = types.UID("garm-runner-" + uid)
+	return vm
+}
+
+func teardownLauncher(name, vmName string) *corev1.Pod {
+	pod := &corev1.Pod{ObjectMeta: teardownMeta(name)}
+	pod.Labels = map[string]string{kubevirtv1.AppLabel: "virt-launcher", kubevirtv1.VirtualMachineNameLabel: vmName}
+	return pod
+}
+
+func teardownPVC(name string) *corev1.PersistentVolumeClaim {
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: teardownMeta(name)}
+	pvc.Finalizers = []string{"kubernetes.io/pvc-protection"}
+	return pvc
+}
+
+func newTeardownProvider(harvesterObjects []runtime.Object, kubeObjects ...runtime.Object) *HarvesterProvider {
+	return &HarvesterProvider{
+		GarmConfig:      &config.Config{Namespace: "garm"},
+		KubeClient:      k8sfake.NewSimpleClientset(kubeObjects...),
+		HarvesterClient: harvfake.NewSimpleClientset(harvesterObjects...),
+	}
+}
+
+func TestTeardownLeftovers(t *testing.T) {
+	tests := []struct {
+		name      string
+		harvester []runtime.Object
+		kube      []runtime.Object
+		want      []string
+	}{
+		{
+			name:      "everything left",
+			harvester: []runtime.Object{teardownVM("a"), &kubevirtv1.VirtualMachineInstance{ObjectMeta: teardownMeta("garm-runner")}},
+			kube: []runtime.Object{
+				teardownLauncher("virt-launcher-garm-runner-abcde", "garm-runner"),
+				teardownLauncher("virt-launcher-garm-runner-2-abcde", "garm-runner-2"),
+				teardownPVC("garm-runner-rootdisk"),
+				teardownPVC("garm-runner-cache"),
+			},
+			want: []string{
+				"vm garm-runner",
+				"vmi garm-runner",
+				"pod virt-launcher-garm-runner-abcde",
+				"pvc garm-runner-rootdisk (finalizers: kubernetes.io/pvc-protection)",
+			},
+		},
+		{
+			// A runner created again under the same name is not a leftover.
+			name:      "vm recreated",
+			harvester: []runtime.Object{teardownVM("b")},
+		},
+		{
+			name: "everything gone",
+			kube: []runtime.Object{teardownPVC("garm-runner-cache")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTeardownProvider(tt.harvester, tt.kube...)
+			remaining, err := h.teardownLeftovers(t.Context(), teardownVM("a"), []string{"garm-runner-rootdisk"})
+			require.NoError(t, err)
+			require.Equal(t, tt.want, remaining)
+		})
+	}
+}
+
+func TestWaitForTeardown(t *testing.T) {
+	interval := pollInterval
+	pollInterval = time.Millisecond
+	t.Cleanup(func() { pollInterval = interval })
+
+	t.Run("waits for leftovers", func(t *testing.T) {
+		h := newTeardownProvider(
+			[]runtime.Object{&kubevirtv1.VirtualMachineInstance{ObjectMeta: teardownMeta("garm-runner")}},
+			teardownLauncher("virt-launcher-garm-runner-abcde", "garm-runner"),
+		)
+		// The VMI and the virt-launcher pod go away on the third check.
+		checks := 0
+		h.HarvesterClient.(*harvfake.Clientset).PrependReactor("get", "virtualmachineinstances", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			checks++
+			if checks < 3 {
+				return false, nil, nil
+			}
+			return true, nil, apierrors.NewNotFound(action.GetResource().GroupResource(), "garm-runner")
+		})
+		h.KubeClient.(*k8sfake.Clientset).PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if checks < 3 {
+				return false, nil, nil
+			}
+			return true, &corev1.PodList{}, nil
+		})
+
+		require.NoError(t, h.waitForTeardown(t.Context(), teardownVM("a"), nil, time.Minute))
+		require.Equal(t, 3, checks)
+	})
+
+	t.Run("everything gone", func(t *testing.T) {
+		h := newTeardownProvider(nil)
+		require.NoError(t, h.waitForTeardown(t.Context(), teardownVM("a"), []string{"garm-runner-rootdisk"}, time.Minute))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		h := newTeardownProvider(nil,
+			teardownLauncher("virt-launcher-garm-runner-abcde", "garm-runner"),
+			teardownPVC("garm-runner-rootdisk"),
+		)
+		err := h.waitForTeardown(t.Context(), teardownVM("a"), []string{"garm-runner-rootdisk"}, 20*time.Millisecond)
+		require.ErrorIs(t, err, garmErrors.ErrTimeout)
+		require.EqualError(t, err, "instance garm-runner was not torn down within 20ms, left: "+
+			"pod virt-launcher-garm-runner-abcde; pvc garm-runner-rootdisk (finalizers: kubernetes.io/pvc-protection): timed out")
+	})
+
+	t.Run("failed check", func(t *testing.T) {
+		h := newTeardownProvider(nil)
+		h.KubeClient.(*k8sfake.Clientset).PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewForbidden(kubeschema.GroupResource{Resource: "pods"}, "", errors.New("denied"))
+		})
+		err := h.waitForTeardown(t.Context(), teardownVM("a"), nil, time.Minute)
+		require.ErrorIs(t, err, garmErrors.ErrUnauthorized)
+		require.ErrorContains(t, err, "failed to wait for instance garm-runner to be torn down")
+	})
+}
@@ -0,0 +1,141 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"garm-provider-harvester/pkg/utils"
+	"log/slog"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+// templateAutoDelete reads the auto-delete policy of the PVCs Harvester creates
+// from the volume claim templates of a VM. Templates without the annotation
+// are left out, so their PVCs are judged by their own metadata.
+func templateAutoDelete(vm *kubevirtv1.VirtualMachine) (map[string]bool, error) {
+	policies := map[string]bool{}
+	templates, ok := vm.Annotations[utils.AnnotationKeyVolumeClaimTemplates]
+	if !ok || templates == "" {
+		return policies, nil
+	}
+	var pvcs []corev1.PersistentVolumeClaim
+	if err := json.Unmarshal([]byte(templates), &pvcs); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", utils.AnnotationKeyVolumeClaimTemplates, err)
+	}
+	for _, pvc := range pvcs {
+		if value, ok := pvc.Annotations[autoDeleteAnnotation]; ok {
+			policies[pvc.Name] = value == "true"
+		}
+	}
+	return policies, nil
+}
+
+// autoDelete reports whether a PVC goes away with its runner. The auto-delete
+// annotation decides when set; otherwise only PVCs carrying this controller's
+// ID, as a label or as the annotation CreateInstance sets, are removed.
+func autoDelete(meta v1.ObjectMeta, controllerID string) bool {
+	if value, ok := meta.Annotations[autoDeleteAnnotation]; ok {
+		return value == "true"
+	}
+	key := fmt.Sprintf("%s/%s", utils.HarvesterAPIGroup, controllerIdConst)
+	return meta.Labels[key] == controllerID || meta.Annotations[key] == controllerID
+}
+
+// vpcsToRemove returns the PVCs of a VM that are deleted with it. Volumes an
+// operator attached on purpose, such as shared caches, are kept.
+func (h *HarvesterProvider) vpcsToRemove(ctx context.Context, vm *kubevirtv1.VirtualMachine) ([]string, error) {
+	deleteConfigs, err := templateAutoDelete(vm)
+	if err != nil {
+		return nil, err
+	}
+	removedPVCs := make([]string, 0, len(vm.Spec.Template.Spec.Volumes))
+	for _, volume := range vm.Spec.Template.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		name := volume.PersistentVolumeClaim.ClaimName
+		if autoDelete, ok := deleteConfigs[name]; ok {
+			if autoDelete {
+				removedPVCs = append(removedPVCs, name)
+			}
+			continue
+		}
+
+		pvc, err := h.KubeClient.CoreV1().PersistentVolumeClaims(h.GarmConfig.Namespace).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, apiError(err, "failed to get pvc %s", name)
+		}
+		if autoDelete(pvc.ObjectMeta, h.ControllerID) {
+			removedPVCs = append(removedPVCs, name)
+		} else {
+			slog.Info(fmt.Sprintf("%s: keeping pvc %s", vm.Name, name))
+		}
+	}
+	return removedPVCs, nil
+}
+
+// boundVolumes returns the persistent volumes the given PVCs are bound to.
+func (h *HarvesterProvider) boundVolumes(ctx context.Context, pvcs []string) ([]string, error) {
+	var volumes []string
+	for _, name := range pvcs {
+		pvc, err := h.KubeClient.CoreV1().PersistentVolumeClaims(h.GarmConfig.Namespace).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, apiError(err, "failed to get pvc %s", name)
+		}
+		if pvc.Spec.VolumeName != "" {
+			volumes = append(volumes, pvc.Spec.VolumeName)
+		}
+	}
+	return volumes, nil
+}
+
+// removeStaleVolumeAttachments deletes the VolumeAttachments of the given
+// persistent volumes that point at nodes which no longer exist. The
+// attach/detach controller only releases attachments of pods it can see
+// terminate; one left on a removed node keeps the volume attached forever.
+// Attachments on live nodes are left to the controller, deleting them would
+// detach a volume still in use. The cleanup is best effort: the runner is
+// already gone and the credentials may not be allowed to see cluster scoped
+// resources, so failures are only logged.
+func (h *HarvesterProvider) removeStaleVolumeAttachments(ctx context.Context, vmName string, volumes []string) {
+	if len(volumes) == 0 {
+		return
+	}
+	attachments, err := h.KubeClient.StorageV1().VolumeAttachments().List(ctx, v1.ListOptions{})
+	if err != nil {
+		slog.Warn(fmt.Sprintf("%s: failed to list volume attachments: %s", vmName, err))
+		return
+	}
+
+	for _, attachment := range attachments.Items {
+		pv := attachment.Spec.Source.PersistentVolumeName
+		if pv == nil || !slices.Contains(volumes, *pv) || attachment.DeletionTimestamp != nil {
+			continue
+		}
+		_, err := h.KubeClient.CoreV1().Nodes().Get(ctx, attachment.Spec.NodeName, v1.GetOptions{})
+		if err == nil {
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			slog.Warn(fmt.Sprintf("%s: failed to get node %s of volume attachment %s: %s", vmName, attachment.Spec.NodeName, attachment.Name, err))
+			continue
+		}
+		err = h.KubeClient.StorageV1().VolumeAttachments().Delete(ctx, attachment.Name, v1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			slog.Warn(fmt.Sprintf("%s: failed to remove volume attachment %s: %s", vmName, attachment.Name, err))
+			continue
+		}
+		slog.Info(fmt.Sprintf("%s: removed volume attachment %s of %s on missing node %s", vmName, attachment.Name, *pv, attachment.Spec.NodeName))
+	}
+}
@@ -0,0 +1,79 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/harvester/harvester/pkg/builder"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTemplateAutoDelete(t *testing.T) {
+	storageClass := "longhorn"
+	option := func(annotations map[string]string) *builder.PersistentVolumeClaimOption {
+		return &builder.PersistentVolumeClaimOption{
+			VolumeMode:       corev1.PersistentVolumeBlock,
+			AccessMode:       corev1.ReadWriteMany,
+			StorageClassName: &storageClass,
+			Annotations:      annotations,
+		}
+	}
+	vm, err := builder.NewVMBuilder("garm-provider").Name("garm-runner").
+		PVCDisk("rootdisk", "virtio", false, false, 1, "10Gi", "garm-runner-rootdisk", option(map[string]string{autoDeleteAnnotation: "true"})).
+		PVCDisk("cache", "virtio", false, false, 0, "10Gi", "garm-runner-cache", option(map[string]string{autoDeleteAnnotation: "false"})).
+		PVCDisk("scratch", "virtio", false, false, 0, "10Gi", "garm-runner-scratch", option(nil)).
+		VM()
+	require.NoError(t, err)
+
+	policies, err := templateAutoDelete(vm)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"garm-runner-rootdisk": true, "garm-runner-cache": false}, policies)
+
+	vm.Annotations = nil
+	policies, err = templateAutoDelete(vm)
+	require.NoError(t, err)
+	require.Empty(t, policies)
+}
+
+func TestAutoDelete(t *testing.T) {
+	tests := []struct {
+		name     string
+		meta     v1.ObjectMeta
+		expected bool
+	}{
+		{
+			name:     "auto-delete annotation",
+			meta:     v1.ObjectMeta{Annotations: map[string]string{autoDeleteAnnotation: "true"}},
+			expected: true,
+		},
+		{
+			name: "auto-delete disabled",
+			meta: v1.ObjectMeta{
+				Labels:      map[string]string{"harvesterhci.io/controller-id": "controller"},
+				Annotations: map[string]string{autoDeleteAnnotation: "false"},
+			},
+			expected: false,
+		},
+		{
+			name:     "controller label",
+			meta:     v1.ObjectMeta{Labels: map[string]string{"harvesterhci.io/controller-id": "controller"}},
+			expected: true,
+		},
+		{
+			name:     "other controller",
+			meta:     v1.ObjectMeta{Labels: map[string]string{"harvesterhci.io/controller-id": "other"}},
+			expected: false,
+		},
+		{
+			name:     "unmarked volume",
+			meta:     v1.ObjectMeta{},
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, autoDelete(tt.meta, "controller"))
+		})
+	}
+}
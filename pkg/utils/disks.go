@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const cloudConfigHeader = "#cloud-config"
+
+// DiskMount is an extra disk cloud-init formats and mounts in a Linux runner.
+type DiskMount struct {
+	Device     string
+	MountPath  string
+	Filesystem string
+}
+
+// DiskDevicePath returns where udev links a disk with the given serial for the
+// bus it is attached to.
+func DiskDevicePath(serial, bus string) string {
+	switch bus {
+	case "sata":
+		return fmt.Sprintf("/dev/disk/by-id/ata-QEMU_HARDDISK_%s", serial)
+	case "scsi":
+		return fmt.Sprintf("/dev/disk/by-id/scsi-0QEMU_QEMU_HARDDISK_%s", serial)
+	default:
+		return fmt.Sprintf("/dev/disk/by-id/virtio-%s", serial)
+	}
+}
+
+// AddDiskMounts adds fs_setup and mounts entries for the disks to a cloud
+// config. cloud-init only creates a filesystem on a disk that has none, so
+// the data of a reattached volume is kept. Mounts use nofail so a missing
+// disk does not stop the runner from booting.
+func AddDiskMounts(userData string, mounts []DiskMount) (string, error) {
+	if len(mounts) == 0 {
+		return userData, nil
+	}
+	if !strings.HasPrefix(userData, cloudConfigHeader) {
+		return "", fmt.Errorf("disks can only be mounted through a cloud config")
+	}
+
+	cloudConfig := map[string]any{}
+	if err := yaml.Unmarshal([]byte(userData), &cloudConfig); err != nil {
+		return "", fmt.Errorf("failed to decode cloud config: %w", err)
+	}
+	fsSetup, _ := cloudConfig["fs_setup"].([]any)
+	fstab, _ := cloudConfig["mounts"].([]any)
+	for _, mount := range mounts {
+		filesystem := mount.Filesystem
+		if filesystem == "" {
+			filesystem = "ext4"
+		}
+		fsSetup = append(fsSetup, map[string]any{
+			"device":     mount.Device,
+			"filesystem": filesystem,
+			"partition":  "none",
+		})
+		fstab = append(fstab, []string{mount.Device, mount.MountPath, filesystem, "defaults,nofail", "0", "2"})
+	}
+	cloudConfig["fs_setup"] = fsSetup
+	cloudConfig["mounts"] = fstab
+
+	data, err := yaml.Marshal(cloudConfig)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cloud config: %w", err)
+	}
+	return cloudConfigHeader + "\n" + string(data), nil
+}
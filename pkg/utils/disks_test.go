@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestDiskDevicePath(t *testing.T) {
+	require.Equal(t, "/dev/disk/by-id/virtio-scratch", DiskDevicePath("scratch", "virtio"))
+	require.Equal(t, "/dev/disk/by-id/ata-QEMU_HARDDISK_scratch", DiskDevicePath("scratch", "sata"))
+	require.Equal(t, "/dev/disk/by-id/scsi-0QEMU_QEMU_HARDDISK_scratch", DiskDevicePath("scratch", "scsi"))
+}
+
+func TestAddDiskMounts(t *testing.T) {
+	userData := "#cloud-config\npackage_upgrade: true\nruncmd:\n    - /install_runner.sh\n"
+
+	unchanged, err := AddDiskMounts(userData, nil)
+	require.NoError(t, err)
+	require.Equal(t, userData, unchanged)
+
+	res, err := AddDiskMounts(userData, []DiskMount{
+		{Device: "/dev/disk/by-id/virtio-scratch", MountPath: "/mnt/scratch"},
+		{Device: "/dev/disk/by-id/virtio-data", MountPath: "/mnt/data", Filesystem: "xfs"},
+	})
+	require.NoError(t, err)
+	require.Regexp(t, "^#cloud-config\n", res)
+
+	cloudConfig := map[string]any{}
+	require.NoError(t, yaml.Unmarshal([]byte(res), &cloudConfig))
+	require.Equal(t, []any{"/install_runner.sh"}, cloudConfig["runcmd"])
+	require.Equal(t, []any{
+		map[string]any{"device": "/dev/disk/by-id/virtio-scratch", "filesystem": "ext4", "partition": "none"},
+		map[string]any{"device": "/dev/disk/by-id/virtio-data", "filesystem": "xfs", "partition": "none"},
+	}, cloudConfig["fs_setup"])
+	require.Equal(t, []any{
+		[]any{"/dev/disk/by-id/virtio-scratch", "/mnt/scratch", "ext4", "defaults,nofail", "0", "2"},
+		[]any{"/dev/disk/by-id/virtio-data", "/mnt/data", "xfs", "defaults,nofail", "0", "2"},
+	}, cloudConfig["mounts"])
+
+	_, err = AddDiskMounts("<powershell>", []DiskMount{{Device: "/dev/vdb", MountPath: "/mnt"}})
+	require.EqualError(t, err, "disks can only be mounted through a cloud config")
+}
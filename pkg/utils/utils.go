@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 
@@ -57,18 +58,43 @@ users:
 	AnnotationKeyVirtualMachineWaitForLeaseInterfaceNames = LabelAnnotationPrefixHarvester + "waitForLeaseInterfaceNames"
 	AnnotationKeyVirtualMachineDiskNames                  = LabelAnnotationPrefixHarvester + "diskNames"
 	AnnotationKeyImageID                                  = LabelAnnotationPrefixHarvester + "imageId"
+	AnnotationKeyVolumeClaimTemplates                     = LabelAnnotationPrefixHarvester + "volumeClaimTemplates"
 
 	AnnotationPrefixCattleField = "field.cattle.io/"
 	LabelPrefixHarvesterTag     = "tag.harvesterhci.io/"
 	AnnotationKeyDescription    = AnnotationPrefixCattleField + "description"
 )
 
-var StatusMap = map[string]string{
-	"ACTIVE":   "Running",
-	"SHUTOFF":  "stopped",
-	"BUILD":    "pending_create",
-	"ERROR":    "error",
-	"DELETING": "pending_delete",
+// StatusMap maps the printable status of a KubeVirt VM to a GARM instance status.
+var StatusMap = map[kubevirtv1.VirtualMachinePrintableStatus]params.InstanceStatus{
+	kubevirtv1.VirtualMachineStatusProvisioning:            params.InstanceCreating,
+	kubevirtv1.VirtualMachineStatusWaitingForVolumeBinding: params.InstanceCreating,
+	kubevirtv1.VirtualMachineStatusStarting:                params.InstanceCreating,
+	kubevirtv1.VirtualMachineStatusRunning:                 params.InstanceRunning,
+	kubevirtv1.VirtualMachineStatusMigrating:               params.InstanceRunning,
+	kubevirtv1.VirtualMachineStatusPaused:                  params.InstanceStopped,
+	kubevirtv1.VirtualMachineStatusStopping:                params.InstanceStopped,
+	kubevirtv1.VirtualMachineStatusStopped:                 params.InstanceStopped,
+	kubevirtv1.VirtualMachineStatusTerminating:             params.InstanceDeleting,
+	kubevirtv1.VirtualMachineStatusUnschedulable:           params.InstanceError,
+	kubevirtv1.VirtualMachineStatusErrImagePull:            params.InstanceError,
+	kubevirtv1.VirtualMachineStatusImagePullBackOff:        params.InstanceError,
+	kubevirtv1.VirtualMachineStatusPvcNotFound:             params.InstanceError,
+	kubevirtv1.VirtualMachineStatusDataVolumeError:         params.InstanceError,
+	kubevirtv1.VirtualMachineStatusCrashLoopBackOff:        params.InstanceError,
+	kubevirtv1.VirtualMachineStatusUnknown:                 params.InstanceStatusUnknown,
+}
+
+// PhaseMap maps the phase of a KubeVirt VMI to a GARM instance status. It is
+// used when the VM does not report a printable status yet.
+var PhaseMap = map[kubevirtv1.VirtualMachineInstancePhase]params.InstanceStatus{
+	kubevirtv1.Pending:    params.InstanceCreating,
+	kubevirtv1.Scheduling: params.InstanceCreating,
+	kubevirtv1.Scheduled:  params.InstanceCreating,
+	kubevirtv1.Running:    params.InstanceRunning,
+	kubevirtv1.Succeeded:  params.InstanceStopped,
+	kubevirtv1.Failed:     params.InstanceError,
+	kubevirtv1.Unknown:    params.InstanceStatusUnknown,
 }
 
 var flavorMap = map[string][]string{
@@ -78,25 +104,105 @@ var flavorMap = map[string][]string{
 	"xlarge": []string{"8", "16Gi", "32Gi"},
 }
 
-func HarvesterVmToInstance(vm *kubevirtv1.VirtualMachineInstance) params.ProviderInstance {
+// HarvesterVmToInstance converts a VM to a GARM instance. The provider ID is the
+// VM name. vmi is nil when the VM has no running instance, in which case no
+// addresses are reported.
+func HarvesterVmToInstance(vm *kubevirtv1.VirtualMachine, vmi *kubevirtv1.VirtualMachineInstance) params.ProviderInstance {
+	addresses := []params.Address{}
+	if vmi != nil {
+		addresses = interfaceAddresses(vmi.Status.Interfaces)
+	}
+
+	status := InstanceStatus(vm, vmi)
+	var fault []byte
+	if status == params.InstanceError {
+		fault = ProviderFault(vm, vmi)
+	}
+
+	return params.ProviderInstance{
+		ProviderID:    vm.Name,
+		Name:          vm.Name,
+		OSArch:        params.OSArch(vm.Spec.Template.Spec.Architecture),
+		OSType:        params.OSType(vm.Labels[fmt.Sprintf("%s/%s", HarvesterAPIGroup, "os-type")]),
+		Status:        status,
+		Addresses:     addresses,
+		ProviderFault: fault,
+	}
+}
+
+// interfaceAddresses returns the IPs of every network interface of a VMI.
+// Link-local addresses reported by the guest agent are left out.
+func interfaceAddresses(interfaces []kubevirtv1.VirtualMachineInstanceNetworkInterface) []params.Address {
 	addresses := []params.Address{}
-	for _, net := range vm.Status.Interfaces {
-		for _, ip := range net.IPs {
+	seen := map[string]bool{}
+	for _, nic := range interfaces {
+		ips := nic.IPs
+		if len(ips) == 0 && nic.IP != "" {
+			ips = []string{nic.IP}
+		}
+		for _, ip := range ips {
+			parsed := net.ParseIP(ip)
+			if parsed == nil || parsed.IsLinkLocalUnicast() || seen[ip] {
+				continue
+			}
+			seen[ip] = true
 			addresses = append(addresses, params.Address{
 				Address: ip,
 				Type:    params.PrivateAddress,
 			})
 		}
 	}
+	return addresses
+}
 
-	return params.ProviderInstance{
-		ProviderID: string(vm.UID),
-		Name:       vm.Name,
-		OSArch:     params.OSArch(vm.Spec.Architecture),
-		OSType:     params.OSType(vm.Labels[fmt.Sprintf("%s/%s", HarvesterAPIGroup, "os-type")]),
-		Status:     params.InstanceStatus(StatusMap[string(vm.Status.Phase)]),
-		Addresses:  addresses,
+// InstanceStatus resolves the GARM status of a VM from its printable status,
+// falling back to the phase of its VMI.
+func InstanceStatus(vm *kubevirtv1.VirtualMachine, vmi *kubevirtv1.VirtualMachineInstance) params.InstanceStatus {
+	if status, ok := StatusMap[vm.Status.PrintableStatus]; ok && status != params.InstanceStatusUnknown {
+		return status
+	}
+	if vmi != nil {
+		if status, ok := PhaseMap[vmi.Status.Phase]; ok {
+			return status
+		}
+	}
+	if vm.Status.PrintableStatus == "" && vmi == nil {
+		return params.InstanceCreating
+	}
+	return params.InstanceStatusUnknown
+}
+
+// ProviderFault collects the messages of the failing VM and VMI conditions.
+// The VM and its VMI often report the same message, it is only kept once.
+func ProviderFault(vm *kubevirtv1.VirtualMachine, vmi *kubevirtv1.VirtualMachineInstance) []byte {
+	messages := []string{}
+	seen := map[string]bool{}
+	add := func(cond string) {
+		if !seen[cond] {
+			seen[cond] = true
+			messages = append(messages, cond)
+		}
+	}
+	for _, cond := range vm.Status.Conditions {
+		if cond.Message == "" {
+			continue
+		}
+		if (cond.Type == kubevirtv1.VirtualMachineFailure && cond.Status == corev1.ConditionTrue) ||
+			cond.Status == corev1.ConditionFalse {
+			add(fmt.Sprintf("%s: %s", cond.Reason, cond.Message))
+		}
+	}
+	if vmi != nil {
+		for _, cond := range vmi.Status.Conditions {
+			if cond.Message != "" && cond.Status == corev1.ConditionFalse {
+				add(fmt.Sprintf("%s: %s", cond.Reason, cond.Message))
+			}
+		}
+	}
+	if len(messages) == 0 {
+		return []byte(vm.Status.PrintableStatus)
 	}
+	return []byte(strings.Join(messages, "; "))
 }
 
 // Accept either a standard size or parse a custom
@@ -114,7 +220,7 @@ func ParseFlavor(flavor string) (cpu_count int, memory string, disksize string,
 	}
 
 	parts := strings.Split(flavor, "-")
-	if parts[0] != "custom" {
+	if len(parts) != 4 || parts[0] != "custom" {
 		return 0, "", "", fmt.Errorf("unkwon flavor %s", flavor)
 	}
 
@@ -133,7 +239,7 @@ func ParseFlavor(flavor string) (cpu_count int, memory string, disksize string,
 	}
 
 	if !strings.HasSuffix(parts[3], "Mi") && !strings.HasSuffix(parts[3], "Gi") {
-		return 0, "", "", fmt.Errorf("unkwon disk format %s", parts[2])
+		return 0, "", "", fmt.Errorf("unkwon disk format %s", parts[3])
 	}
 
 	return cores, parts[2], parts[3], nil
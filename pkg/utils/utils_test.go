@@ -0,0 +1,196 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/cloudbase/garm-provider-common/params"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+)
+
+func TestParseFlavor(t *testing.T) {
+	tests := []struct {
+		name      string
+		flavor    string
+		cores     int
+		memory    string
+		disk      string
+		errString string
+	}{
+		{
+			name:   "standard flavor",
+			flavor: "medium",
+			cores:  1,
+			memory: "2Gi",
+			disk:   "12Gi",
+		},
+		{
+			name:   "custom flavor",
+			flavor: "custom-4c-16Gi-164Gi",
+			cores:  4,
+			memory: "16Gi",
+			disk:   "164Gi",
+		},
+		{
+			name:      "empty flavor",
+			flavor:    "",
+			errString: "unkwon flavor ",
+		},
+		{
+			name:      "truncated custom flavor",
+			flavor:    "custom-4c",
+			errString: "unkwon flavor custom-4c",
+		},
+		{
+			name:      "bad disk format",
+			flavor:    "custom-4c-16Gi-164",
+			errString: "unkwon disk format 164",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cores, memory, disk, err := ParseFlavor(tt.flavor)
+			if tt.errString != "" {
+				require.EqualError(t, err, tt.errString)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.cores, cores)
+			require.Equal(t, tt.memory, memory)
+			require.Equal(t, tt.disk, disk)
+		})
+	}
+}
+
+func TestHarvesterVmToInstance(t *testing.T) {
+	vm := &kubevirtv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "garm-runner",
+			UID:    "4f1c2b7e-0d8a-4c2f-9d51-6a0f3b2e9c11",
+			Labels: map[string]string{"harvesterhci.io/os-type": "linux"},
+		},
+		Spec: kubevirtv1.VirtualMachineSpec{
+			Template: &kubevirtv1.VirtualMachineInstanceTemplateSpec{
+				Spec: kubevirtv1.VirtualMachineInstanceSpec{Architecture: "amd64"},
+			},
+		},
+	}
+
+	stopped := HarvesterVmToInstance(vm, nil)
+	require.Equal(t, "garm-runner", stopped.Name)
+	require.Equal(t, "garm-runner", stopped.ProviderID)
+	require.Equal(t, params.Linux, stopped.OSType)
+	require.Equal(t, params.OSArch("amd64"), stopped.OSArch)
+	require.Empty(t, stopped.Addresses)
+
+	vmi := &kubevirtv1.VirtualMachineInstance{
+		Status: kubevirtv1.VirtualMachineInstanceStatus{
+			Interfaces: []kubevirtv1.VirtualMachineInstanceNetworkInterface{
+				{IPs: []string{"10.0.0.5"}},
+			},
+		},
+	}
+	running := HarvesterVmToInstance(vm, vmi)
+	require.Equal(t, []params.Address{{Address: "10.0.0.5", Type: params.PrivateAddress}}, running.Addresses)
+
+	vmi.Status.Interfaces = []kubevirtv1.VirtualMachineInstanceNetworkInterface{
+		{Name: "nic-0", IP: "10.0.0.5", IPs: []string{"10.0.0.5", "fe80::1"}},
+		{Name: "nic-1", IP: "192.168.10.20"},
+		{Name: "nic-2", IPs: []string{"192.168.20.20", "2001:db8::20"}},
+	}
+	multiNIC := HarvesterVmToInstance(vm, vmi)
+	require.Equal(t, []params.Address{
+		{Address: "10.0.0.5", Type: params.PrivateAddress},
+		{Address: "192.168.10.20", Type: params.PrivateAddress},
+		{Address: "192.168.20.20", Type: params.PrivateAddress},
+		{Address: "2001:db8::20", Type: params.PrivateAddress},
+	}, multiNIC.Addresses)
+}
+
+func TestInstanceStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		vm     kubevirtv1.VirtualMachineStatus
+		vmi    *kubevirtv1.VirtualMachineInstance
+		status params.InstanceStatus
+	}{
+		{
+			name:   "new VM",
+			vm:     kubevirtv1.VirtualMachineStatus{},
+			status: params.InstanceCreating,
+		},
+		{
+			name:   "running",
+			vm:     kubevirtv1.VirtualMachineStatus{PrintableStatus: kubevirtv1.VirtualMachineStatusRunning},
+			status: params.InstanceRunning,
+		},
+		{
+			name:   "stopped",
+			vm:     kubevirtv1.VirtualMachineStatus{PrintableStatus: kubevirtv1.VirtualMachineStatusStopped},
+			status: params.InstanceStopped,
+		},
+		{
+			name:   "terminating",
+			vm:     kubevirtv1.VirtualMachineStatus{PrintableStatus: kubevirtv1.VirtualMachineStatusTerminating},
+			status: params.InstanceDeleting,
+		},
+		{
+			name:   "unschedulable",
+			vm:     kubevirtv1.VirtualMachineStatus{PrintableStatus: kubevirtv1.VirtualMachineStatusUnschedulable},
+			status: params.InstanceError,
+		},
+		{
+			name: "falls back to VMI phase",
+			vm:   kubevirtv1.VirtualMachineStatus{PrintableStatus: kubevirtv1.VirtualMachineStatusUnknown},
+			vmi: &kubevirtv1.VirtualMachineInstance{
+				Status: kubevirtv1.VirtualMachineInstanceStatus{Phase: kubevirtv1.Scheduling},
+			},
+			status: params.InstanceCreating,
+		},
+		{
+			name:   "unknown",
+			vm:     kubevirtv1.VirtualMachineStatus{PrintableStatus: "SomethingNew"},
+			status: params.InstanceStatusUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := &kubevirtv1.VirtualMachine{Status: tt.vm}
+			require.Equal(t, tt.status, InstanceStatus(vm, tt.vmi))
+		})
+	}
+}
+
+func TestProviderFault(t *testing.T) {
+	vm := &kubevirtv1.VirtualMachine{
+		Spec: kubevirtv1.VirtualMachineSpec{Template: &kubevirtv1.VirtualMachineInstanceTemplateSpec{}},
+		Status: kubevirtv1.VirtualMachineStatus{
+			PrintableStatus: kubevirtv1.VirtualMachineStatusUnschedulable,
+			Conditions: []kubevirtv1.VirtualMachineCondition{
+				{
+					Type:    kubevirtv1.VirtualMachineReady,
+					Status:  corev1.ConditionFalse,
+					Reason:  "Unschedulable",
+					Message: "0/3 nodes are available: 3 Insufficient memory.",
+				},
+			},
+		},
+	}
+	instance := HarvesterVmToInstance(vm, nil)
+	require.Equal(t, params.InstanceError, instance.Status)
+	require.Equal(t, "Unschedulable: 0/3 nodes are available: 3 Insufficient memory.", string(instance.ProviderFault))
+
+	vmi := &kubevirtv1.VirtualMachineInstance{}
+	vmi.Status.Conditions = []kubevirtv1.VirtualMachineInstanceCondition{
+		{Type: kubevirtv1.VirtualMachineInstanceReady, Status: corev1.ConditionFalse, Reason: "GuestNotRunning", Message: "Guest VM is not reported as running"},
+		{Type: kubevirtv1.VirtualMachineInstanceProvisioning, Status: corev1.ConditionFalse, Reason: "Unschedulable", Message: "0/3 nodes are available: 3 Insufficient memory."},
+	}
+	require.Equal(t, "Unschedulable: 0/3 nodes are available: 3 Insufficient memory.; GuestNotRunning: Guest VM is not reported as running", string(ProviderFault(vm, vmi)))
+
+	vm.Status.Conditions = nil
+	require.Equal(t, "ErrorUnschedulable", string(ProviderFault(vm, nil)))
+}